@@ -20,34 +20,64 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// CMAudience is a consumer of the rendered ConfigMap. Kind is Pod, Deployment,
+// ReplicaSet, StatefulSet, DaemonSet, Job or CronJob, or the kind of a custom
+// controller's resource. Pods with a generateName are tracked under it, so the
+// pods of one owner share a single entry.
 type CMAudience struct {
-	Kind string `json:"kind"`
-	Name string `json:"name"`
+	// APIVersion is only set for the resources of custom controllers.
+	// +optional
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
 }
 
 // Important: Run "make" to regenerate code after modifying this file
 // CMStateSpec defines the desired state of CMState
 type CMStateSpec struct {
+	// Audience lists the workloads consuming the rendered ConfigMap. A CMState that
+	// is not static is deleted once its audience is empty.
+	// +optional
 	Audience   []CMAudience `json:"audience"`
 	Target     string       `json:"target,omitempty"`
 	CMTemplate string       `json:"cmtemplate"`
+
+	// Static marks a CMState that is maintained by hand, e.g. through GitOps. It is
+	// rendered from Values and kept regardless of its audience.
+	// +optional
+	Static bool `json:"static,omitempty"`
+
+	// Values holds the replacement values captured at admission, or supplied by hand
+	// for static CMStates, keyed by the pod annotation for annotationreplace entries
+	// and by name for value sources.
+	// +optional
+	Values map[string]string `json:"values,omitempty"`
 }
 
 // CMStateStatus defines the observed state of CMState
 type CMStateStatus struct {
-	// Represents the observations of a Memcached's current state.
-	// condition types may define expected values and meanings for this field, and whether the values
-	// are considered a guaranteed API.
-	// For further information see: https://github.com/kubernetes/community/blob/master/contributors/devel/sig-architecture/api-conventions.md#typical-status-properties
+	// ObservedGeneration is the generation of the spec the status was computed from.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
 
-	// Conditions store the status conditions of the Memcached instances
+	// Conditions are Ready, Rendered, Injected, Degraded and Terminating, see
+	// ConditionReady and the other condition types for their meaning.
+	// For further information see: https://github.com/kubernetes/community/blob/master/contributors/devel/sig-architecture/api-conventions.md#typical-status-properties
 	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +listType=map
+	// +listMapKey=type
+	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 //+kubebuilder:resource:scope=Namespaced
+//+kubebuilder:printcolumn:name="Template",type=string,JSONPath=`.spec.cmtemplate`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+//+kubebuilder:selectablefield:JSONPath=`.spec.cmtemplate`
 
 // CMState is the Schema for the cmstates API
 type CMState struct {
@@ -17,16 +17,78 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
 type Template struct {
-	AnnotationReplace map[string]string `json:"annotationreplace"`
+	AnnotationReplace map[string]string `json:"annotationreplace,omitempty"`
 	CMTemplate        map[string]string `json:"cmtemplate"`
 	TargetAnnotation  string            `json:"targetAnnotation"`
+
+	// ValueSources resolves placeholders from sources other than pod annotations.
+	// +optional
+	ValueSources []ValueSource `json:"valueSources,omitempty"`
+
+	// MountPath mounts the rendered ConfigMap into every container of the pod at this path.
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+	// EnvFrom exposes the rendered keys as environment variables of every container of the pod.
+	// +optional
+	EnvFrom bool `json:"envFrom,omitempty"`
+}
+
+// ValueSource resolves a single placeholder in the template. Exactly one of the
+// source fields must be set.
+type ValueSource struct {
+	// Name identifies the value. Values captured from the pod at admission are
+	// stored on the CMState under this name.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9_.]*[a-z0-9])?$`
+	// +kubebuilder:validation:MaxLength=63
+	Name string `json:"name"`
+	// Placeholder is the string in the template that gets replaced by the value.
+	Placeholder string `json:"placeholder"`
+
+	// PodLabel reads the value from a label on the pod.
+	// +optional
+	PodLabel string `json:"podLabel,omitempty"`
+	// PodAnnotation reads the value from an annotation on the pod.
+	// +optional
+	PodAnnotation string `json:"podAnnotation,omitempty"`
+	// NamespaceLabel reads the value from a label on the pod's namespace.
+	// +optional
+	NamespaceLabel string `json:"namespaceLabel,omitempty"`
+	// NamespaceAnnotation reads the value from an annotation on the pod's namespace.
+	// +optional
+	NamespaceAnnotation string `json:"namespaceAnnotation,omitempty"`
+	// PodField reads the value from the pod itself. Supported paths are
+	// metadata.name, metadata.namespace, spec.serviceAccountName,
+	// spec.containers[<name>].image and spec.containers[<name>].ports[<name>].
+	// +optional
+	PodField string `json:"podField,omitempty"`
+	// ConfigMapKeyRef reads the value from a key of a ConfigMap in the CMState's namespace.
+	// +optional
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+	// SecretKeyRef reads the value from a key of a Secret in the CMState's namespace.
+	// +optional
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+	// Value is a literal constant.
+	// +optional
+	Value *string `json:"value,omitempty"`
+}
+
+// FromPod reports whether the value has to be captured from the pod (or its
+// namespace) at admission, rather than resolved when rendering.
+func (v ValueSource) FromPod() bool {
+	return v.PodLabel != "" || v.PodAnnotation != "" || v.NamespaceLabel != "" ||
+		v.NamespaceAnnotation != "" || v.PodField != ""
 }
 
 // CMTemplateSpec defines the desired state of CMTemplate
@@ -35,17 +97,102 @@ type CMTemplateSpec struct {
 	// Important: Run "make" to regenerate code after modifying this file
 
 	Template Template `json:"template,omitempty"`
+
+	// NamespaceSelector selects the namespaces whose pods may use this template.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// AllowedNamespaces lists the namespaces whose pods may use this template.
+	// When neither this nor NamespaceSelector is set, every namespace is allowed.
+	// +optional
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+	// NamespacePolicy decides what happens to a pod in a namespace that may not
+	// use this template: Deny rejects the pod, Skip admits it without injection.
+	// +kubebuilder:validation:Enum=Deny;Skip
+	// +kubebuilder:default=Skip
+	// +optional
+	NamespacePolicy NamespacePolicy `json:"namespacePolicy,omitempty"`
+
+	// Selector makes the webhook apply this template to every matching pod that
+	// does not name a template through the cache.spicedelver.me/cmtemplate annotation.
+	// +optional
+	Selector *TemplateSelector `json:"selector,omitempty"`
+
+	// FailurePolicy decides what happens to a pod when this template cannot be
+	// applied to it: a value is missing or the CMState cannot be created. Deny
+	// rejects the pod, AllowWithWarning and AllowSilently admit it. When unset the
+	// operator wide default is used, which also covers templates that do not exist.
+	// +kubebuilder:validation:Enum=Deny;AllowWithWarning;AllowSilently
+	// +optional
+	FailurePolicy FailurePolicy `json:"failurePolicy,omitempty"`
+}
+
+// TemplateSelector selects the pods a template is applied to. A pod matches
+// when it matches every selector that is set and every match condition.
+type TemplateSelector struct {
+	// PodSelector selects pods by their labels. When unset every pod matches.
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// NamespaceSelector selects pods by the labels of their namespace. When unset
+	// every namespace matches.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// MatchConditions are CEL expressions that must all evaluate to true. The pod
+	// is available as `object` and its namespace as `namespaceObject`.
+	// +optional
+	MatchConditions []MatchCondition `json:"matchConditions,omitempty"`
+}
+
+// MatchCondition is a named CEL expression evaluated against a pod.
+type MatchCondition struct {
+	// Name identifies the condition in error messages.
+	Name string `json:"name"`
+	// Expression must evaluate to a bool.
+	Expression string `json:"expression"`
 }
 
+// NamespacePolicy decides how the webhook treats pods in namespaces that may
+// not use a template.
+type NamespacePolicy string
+
+const (
+	// NamespacePolicyDeny rejects the pod.
+	NamespacePolicyDeny NamespacePolicy = "Deny"
+	// NamespacePolicySkip admits the pod without injecting the template.
+	NamespacePolicySkip NamespacePolicy = "Skip"
+)
+
+// FailurePolicy decides how the webhook treats pods a template cannot be applied to.
+type FailurePolicy string
+
+const (
+	// FailurePolicyDeny rejects the pod with the reason of the failure.
+	FailurePolicyDeny FailurePolicy = "Deny"
+	// FailurePolicyAllowWithWarning admits the pod and returns the failure as an admission warning.
+	FailurePolicyAllowWithWarning FailurePolicy = "AllowWithWarning"
+	// FailurePolicyAllowSilently admits the pod.
+	FailurePolicyAllowSilently FailurePolicy = "AllowSilently"
+)
+
 // CMTemplateStatus defines the observed state of CMTemplate
 type CMTemplateStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ObservedGeneration is the generation of the spec the status was computed from.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions are Ready, Rendered, Injected and Degraded, summarizing the CMStates
+	// of the template. See ConditionReady and the other condition types.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 //+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // CMTemplate is the Schema for the cmtemplates API
 type CMTemplate struct {
@@ -65,6 +212,30 @@ type CMTemplateList struct {
 	Items           []CMTemplate `json:"items"`
 }
 
+// RestrictsNamespaces reports whether the template limits which namespaces may use it.
+func (t *CMTemplate) RestrictsNamespaces() bool {
+	return t.Spec.NamespaceSelector != nil || len(t.Spec.AllowedNamespaces) > 0
+}
+
+// AllowsNamespace reports whether pods in the namespace may use the template,
+// and why not when they may not. A namespace is allowed when it is listed in
+// AllowedNamespaces or matches NamespaceSelector.
+func (t *CMTemplate) AllowsNamespace(namespace *corev1.Namespace) (bool, string, error) {
+	if !t.RestrictsNamespaces() || slices.Contains(t.Spec.AllowedNamespaces, namespace.Name) {
+		return true, "", nil
+	}
+	if t.Spec.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(t.Spec.NamespaceSelector)
+		if err != nil {
+			return false, "", fmt.Errorf("invalid namespaceSelector on cmtemplate %s: %w", t.Name, err)
+		}
+		if selector.Matches(labels.Set(namespace.Labels)) {
+			return true, "", nil
+		}
+	}
+	return false, fmt.Sprintf("namespace %s is not allowed to use cmtemplate %s", namespace.Name, t.Name), nil
+}
+
 func init() {
 	SchemeBuilder.Register(&CMTemplate{}, &CMTemplateList{})
 }
@@ -0,0 +1,61 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+// Types of the conditions on CMStates and CMTemplates. Every reconcile sets all of
+// them, with the observedGeneration of the spec they were computed from, so
+// "kubectl wait --for=condition=Ready" and health checks can rely on them.
+const (
+	// ConditionReady summarizes the other conditions. A CMState is ready when its
+	// ConfigMap is rendered from the current template and values and it is not
+	// terminating. A CMTemplate is ready when all its CMStates are.
+	ConditionReady = "Ready"
+	// ConditionRendered is true when the ConfigMap of a CMState, or of every CMState of
+	// a CMTemplate, holds the current render.
+	ConditionRendered = "Rendered"
+	// ConditionInjected is true when a CMState has an audience, or when a CMTemplate
+	// has CMStates, i.e. pods have been injected with it.
+	ConditionInjected = "Injected"
+	// ConditionDegraded is true when rendering failed. A ConfigMap that was rendered
+	// before keeps its last content until rendering succeeds again.
+	ConditionDegraded = "Degraded"
+	// ConditionTerminating is true while a CMState whose audience emptied is being removed.
+	ConditionTerminating = "Terminating"
+)
+
+// Reasons of the conditions on CMStates and CMTemplates.
+const (
+	// ReasonRendered: the ConfigMaps hold the current render.
+	ReasonRendered = "Rendered"
+	// ReasonRenderFailed: a ConfigMap cannot be rendered, the message says why.
+	ReasonRenderFailed = "RenderFailed"
+	// ReasonAsExpected: nothing is wrong, used when Degraded or Terminating are false.
+	ReasonAsExpected = "AsExpected"
+	// ReasonAudienceJoined: the CMState has workloads or pods in its audience.
+	ReasonAudienceJoined = "AudienceJoined"
+	// ReasonStatic: the CMState has no audience but is maintained by hand.
+	ReasonStatic = "Static"
+	// ReasonAudienceEmpty: the CMState lost its audience and is removed.
+	ReasonAudienceEmpty = "AudienceEmpty"
+	// ReasonCMStatesExist: pods have been injected with the CMTemplate.
+	ReasonCMStatesExist = "CMStatesExist"
+	// ReasonNoCMStates: no pod has been injected with the CMTemplate yet.
+	ReasonNoCMStates = "NoCMStates"
+	// ReasonInvalidSelector: the selector of the CMTemplate cannot be matched, pods are
+	// not selected by it until it is fixed.
+	ReasonInvalidSelector = "InvalidSelector"
+)
@@ -0,0 +1,38 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+// Reasons of the events recorded on CMStates, CMTemplates and the ConfigMaps rendered
+// for them. They are part of the API, so alerts can be built on them.
+const (
+	// EventReasonCMStateCreated is recorded when the webhook creates a CMState for a template.
+	EventReasonCMStateCreated = "CMStateCreated"
+	// EventReasonConfigMapCreated is recorded when the ConfigMap of a CMState is first rendered.
+	EventReasonConfigMapCreated = "ConfigMapCreated"
+	// EventReasonConfigMapRendered is recorded when the ConfigMap of a CMState is re-rendered
+	// because its data changed.
+	EventReasonConfigMapRendered = "ConfigMapRendered"
+	// EventReasonRenderFailed is recorded when the ConfigMap of a CMState cannot be rendered.
+	EventReasonRenderFailed = "RenderFailed"
+	// EventReasonAudienceChanged is recorded when members join or leave the audience of a CMState.
+	EventReasonAudienceChanged = "AudienceChanged"
+	// EventReasonPendingDeletion is recorded when a CMState is about to be removed, either
+	// because it is being deleted or because its audience is empty.
+	EventReasonPendingDeletion = "PendingDeletion"
+	// EventReasonCleanedUp is recorded when a CMState and its ConfigMap have been removed.
+	EventReasonCleanedUp = "CleanedUp"
+)
@@ -0,0 +1,209 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-cmstate inspects CMStates, the ConfigMaps they render and the pods that
+// consume them. Installed on the PATH it runs as "kubectl cmstate".
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	"github.com/stollenaar/cmstate-injector-operator/internal/plugin"
+	webhookcachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/internal/webhook/cache/v1alpha1"
+)
+
+const usage = `Inspect CMStates and the pods consuming them.
+
+Usage:
+  kubectl cmstate list [-n NAMESPACE | -A]
+  kubectl cmstate describe NAME [-n NAMESPACE]
+  kubectl cmstate pod NAME [-n NAMESPACE]
+  kubectl cmstate uninjected [-n NAMESPACE | -A]
+  kubectl cmstate render -f MANIFEST... [--set KEY=VALUE...] [-n NAMESPACE]
+
+render prints the ConfigMaps and pod patches the operator would produce from the
+CMTemplates and pods in the manifests, without a cluster. Without pods the templates
+are rendered from the --set values.
+
+Flags:
+`
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(cachev1alpha1.AddToScheme(scheme))
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	var kubeconfig, kubecontext, namespace string
+	var allNamespaces bool
+	flags := flag.NewFlagSet("kubectl-cmstate", flag.ContinueOnError)
+	flags.StringVar(&kubeconfig, "kubeconfig", "", "Path to the kubeconfig file.")
+	flags.StringVar(&kubecontext, "context", "", "The kubeconfig context to use.")
+	flags.StringVar(&namespace, "namespace", "", "The namespace to look in.")
+	flags.StringVar(&namespace, "n", "", "The namespace to look in (shorthand).")
+	flags.BoolVar(&allNamespaces, "all-namespaces", false, "Look in all namespaces.")
+	flags.BoolVar(&allNamespaces, "A", false, "Look in all namespaces (shorthand).")
+	var manifests []string
+	values := map[string]string{}
+	failurePolicy := string(cachev1alpha1.FailurePolicyAllowWithWarning)
+	flags.Func("f", "A manifest to render, may be repeated.", func(value string) error {
+		manifests = append(manifests, value)
+		return nil
+	})
+	flags.Func("set", "A value to render the templates with as KEY=VALUE, may be repeated.", func(value string) error {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("%q is not KEY=VALUE", value)
+		}
+		values[key] = val
+		return nil
+	})
+	flags.Func("default-failure-policy",
+		"The failure policy of templates without one when rendering: Deny, AllowWithWarning or AllowSilently (default "+
+			failurePolicy+").", func(value string) error {
+			switch cachev1alpha1.FailurePolicy(value) {
+			case cachev1alpha1.FailurePolicyDeny, cachev1alpha1.FailurePolicyAllowWithWarning, cachev1alpha1.FailurePolicyAllowSilently:
+				failurePolicy = value
+				return nil
+			}
+			return fmt.Errorf("%q is not Deny, AllowWithWarning or AllowSilently", value)
+		})
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		flags.Usage()
+		return fmt.Errorf("missing command")
+	}
+
+	command, positional := positional[0], positional[1:]
+	if command == "render" {
+		if namespace == "" {
+			namespace = metav1.NamespaceDefault
+		}
+		return render(manifests, namespace, values, cachev1alpha1.FailurePolicy(failurePolicy))
+	}
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules,
+		&clientcmd.ConfigOverrides{CurrentContext: kubecontext})
+	if namespace == "" {
+		if namespace, _, err = clientConfig.Namespace(); err != nil {
+			return err
+		}
+	}
+	restConfig, err := clientConfig.ClientConfig()
+	if err != nil {
+		return err
+	}
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return err
+	}
+
+	options := &plugin.Options{
+		Client:        c,
+		Namespace:     namespace,
+		AllNamespaces: allNamespaces,
+		Out:           os.Stdout,
+	}
+	ctx := context.Background()
+	switch command {
+	case "list":
+		return options.List(ctx)
+	case "describe":
+		if len(positional) != 1 {
+			return fmt.Errorf("describe takes the name of a cmstate")
+		}
+		return options.Describe(ctx, positional[0])
+	case "pod":
+		if len(positional) != 1 {
+			return fmt.Errorf("pod takes the name of a pod")
+		}
+		return options.Pod(ctx, positional[0])
+	case "uninjected":
+		return options.Uninjected(ctx)
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+func render(manifests []string, namespace string, values map[string]string, failurePolicy cachev1alpha1.FailurePolicy) error {
+	if len(manifests) == 0 {
+		return fmt.Errorf("render takes at least one manifest")
+	}
+	options := &plugin.RenderOptions{
+		Scheme:               scheme,
+		Namespace:            namespace,
+		Values:               values,
+		DefaultFailurePolicy: failurePolicy,
+		DeniedNamespaces:     webhookcachev1alpha1.DefaultDeniedNamespaces,
+		Out:                  os.Stdout,
+	}
+	for _, manifest := range manifests {
+		file, err := os.Open(manifest)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		options.Manifests = append(options.Manifests, file)
+	}
+	return options.Render(context.Background())
+}
+
+// parse parses flags anywhere between the positional arguments, the way kubectl does.
+func parse(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
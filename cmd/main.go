@@ -17,20 +17,27 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
@@ -38,7 +45,12 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	"github.com/stollenaar/cmstate-injector-operator/internal/audit"
 	"github.com/stollenaar/cmstate-injector-operator/internal/controller"
+	"github.com/stollenaar/cmstate-injector-operator/internal/health"
+	"github.com/stollenaar/cmstate-injector-operator/internal/metrics"
+	"github.com/stollenaar/cmstate-injector-operator/internal/preview"
+	"github.com/stollenaar/cmstate-injector-operator/internal/tracing"
 	webhookv1alpha1 "github.com/stollenaar/cmstate-injector-operator/internal/webhook/cache/v1alpha1"
 	// +kubebuilder:scaffold:imports
 )
@@ -60,10 +72,19 @@ func main() {
 	var metricsAddr string
 	var metricsCertPath, metricsCertName, metricsCertKey string
 	var webhookCertPath, webhookCertName, webhookCertKey string
+	var webhookPort int
 	var enableLeaderElection bool
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var defaultFailurePolicy string
+	var deniedNamespaces string
+	var tracingExporter, tracingEndpoint string
+	var auditLog string
+	var auditLogMaxBytes int64
+	var auditLogMaxBackups int
+	var reconcileTimeout time.Duration
+	var enablePreview bool
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -76,12 +97,33 @@ func main() {
 	flag.StringVar(&webhookCertPath, "webhook-cert-path", "", "The directory that contains the webhook certificate.")
 	flag.StringVar(&webhookCertName, "webhook-cert-name", "tls.crt", "The name of the webhook certificate file.")
 	flag.StringVar(&webhookCertKey, "webhook-cert-key", "tls.key", "The name of the webhook key file.")
+	flag.IntVar(&webhookPort, "webhook-port", webhook.DefaultPort, "The port the webhook server serves on.")
 	flag.StringVar(&metricsCertPath, "metrics-cert-path", "",
 		"The directory that contains the metrics server certificate.")
 	flag.StringVar(&metricsCertName, "metrics-cert-name", "tls.crt", "The name of the metrics server certificate file.")
 	flag.StringVar(&metricsCertKey, "metrics-cert-key", "tls.key", "The name of the metrics server key file.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&defaultFailurePolicy, "default-failure-policy", string(cachev1alpha1.FailurePolicyAllowWithWarning),
+		"What the webhook does with a pod when a cmtemplate without a failurePolicy, or one that does not exist, "+
+			"cannot be applied: Deny, AllowWithWarning or AllowSilently.")
+	flag.StringVar(&deniedNamespaces, "denied-namespaces", strings.Join(webhookv1alpha1.DefaultDeniedNamespaces, ","),
+		"Comma separated namespaces whose pods are never injected.")
+	flag.StringVar(&tracingExporter, "tracing-exporter", tracing.ExporterNone,
+		"Where OpenTelemetry spans are exported: none, otlp or stdout. The otlp exporter is configured "+
+			"through the standard OTEL_EXPORTER_OTLP_* environment variables.")
+	flag.StringVar(&tracingEndpoint, "tracing-endpoint", "",
+		"The host:port of the OTLP gRPC endpoint, overriding OTEL_EXPORTER_OTLP_ENDPOINT.")
+	flag.StringVar(&auditLog, "audit-log", "",
+		"Where admission decisions and renders are audited as JSON lines: stdout, or the path of a file "+
+			"that is rotated. Leave empty to disable the audit log.")
+	flag.Int64Var(&auditLogMaxBytes, "audit-log-max-bytes", 100<<20,
+		"The size in bytes at which the audit log file is rotated.")
+	flag.IntVar(&auditLogMaxBackups, "audit-log-max-backups", 5, "The number of rotated audit log files to keep.")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", 10*time.Minute,
+		"How long a reconcile may run before the liveness check reports the controller as stuck.")
+	flag.BoolVar(&enablePreview, "enable-preview", true,
+		"If set, the webhook server previews pods at "+preview.Path+" for callers that may create them.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -90,6 +132,13 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	ctx := ctrl.SetupSignalHandler()
+	shutdownTracing, err := tracing.Setup(ctx, tracingExporter, tracingEndpoint)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -131,6 +180,7 @@ func main() {
 	}
 
 	webhookServer := webhook.NewServer(webhook.Options{
+		Port:    webhookPort,
 		TLSOpts: webhookTLSOpts,
 	})
 
@@ -186,6 +236,11 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "c2c474d3.spicedelver.me",
+		Client: client.Options{Cache: &client.CacheOptions{
+			// ConfigMaps and Secrets are only read while rendering, caching them would hold
+			// every one of them in the cluster. They are watched by their metadata instead.
+			DisableFor: []client.Object{&corev1.ConfigMap{}, &corev1.Secret{}},
+		}},
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -203,27 +258,63 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := (&controller.CMTemplateReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+	watchdog := health.NewWatchdog(reconcileTimeout)
+	auditLogger, err := audit.Open(auditLog, auditLogMaxBytes, auditLogMaxBackups)
+	if err != nil {
+		setupLog.Error(err, "unable to open audit log")
+		os.Exit(1)
+	}
+
+	templateReconciler := &controller.CMTemplateReconciler{
+		Client:   &tracing.Client{Client: mgr.GetClient()},
+		Scheme:   mgr.GetScheme(),
+		Watchdog: watchdog,
+	}
+	if err := templateReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CMTemplate")
 		os.Exit(1)
 	}
 	if err := (&controller.CMStateReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:    &tracing.Client{Client: mgr.GetClient()},
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("cmstate-controller"),
+		APIReader: &tracing.Reader{Reader: mgr.GetAPIReader()},
+		Audit:     auditLogger,
+		Watchdog:  watchdog,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CMState")
 		os.Exit(1)
 	}
+	if err := metrics.RegisterStateCollector(mgr.GetClient()); err != nil {
+		setupLog.Error(err, "unable to register metrics collector")
+		os.Exit(1)
+	}
 
 	// nolint:goconst
 	// if os.Getenv("ENABLE_WEBHOOKS") != "false" {
-	if err = webhookv1alpha1.CMStateCreator(mgr); err != nil {
+	deniedNamespaceList := strings.FieldsFunc(deniedNamespaces, func(r rune) bool { return r == ',' })
+	if err = webhookv1alpha1.CMStateCreator(
+		mgr,
+		cachev1alpha1.FailurePolicy(defaultFailurePolicy),
+		deniedNamespaceList,
+		auditLogger,
+	); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CMTemplate")
 		os.Exit(1)
 	}
+	if enablePreview {
+		previewHandler, err := preview.NewHandler(mgr.GetConfig(), mgr.GetHTTPClient(), &preview.Previewer{
+			Client:               mgr.GetClient(),
+			Scheme:               mgr.GetScheme(),
+			DefaultFailurePolicy: cachev1alpha1.FailurePolicy(defaultFailurePolicy),
+			DeniedNamespaces:     deniedNamespaceList,
+		})
+		if err != nil {
+			setupLog.Error(err, "unable to create preview handler")
+			os.Exit(1)
+		}
+		webhookServer.Register(preview.Path, previewHandler)
+	}
 	// }
 	// +kubebuilder:scaffold:builder
 
@@ -247,13 +338,32 @@ func main() {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up ready check")
+	if err := mgr.AddHealthzCheck("reconcile", watchdog.Check); err != nil {
+		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
+	readyChecks := map[string]healthz.Checker{
+		"webhook":             webhookServer.StartedChecker(),
+		"webhook-certificate": health.ServingCertificate(net.JoinHostPort("localhost", strconv.Itoa(webhookPort))),
+		"cache":               health.CacheSynced(mgr.GetCache()),
+		// The objects the webhook reads through the cache, on every replica
+		"webhook-cache": health.InformersSynced(mgr.GetCache(),
+			&cachev1alpha1.CMTemplate{}, &cachev1alpha1.CMState{}, &corev1.Namespace{}),
+	}
+	for name, check := range readyChecks {
+		if err := mgr.AddReadyzCheck(name, check); err != nil {
+			setupLog.Error(err, "unable to set up ready check", "check", name)
+			os.Exit(1)
+		}
+	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	err = mgr.Start(ctx)
+	// The signal context is done by now, flush the remaining spans on a fresh one
+	if err := shutdownTracing(context.Background()); err != nil {
+		setupLog.Error(err, "unable to flush traces")
+	}
+	if err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
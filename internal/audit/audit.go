@@ -0,0 +1,163 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit writes the decisions of the webhook and the renders of the controller
+// as JSON lines, answering which pod got which config, from which template revision,
+// requested by whom.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+)
+
+// Kinds of audit records.
+const (
+	KindAdmission = "admission"
+	KindRender    = "render"
+)
+
+// Outcomes of render records.
+const (
+	RenderCreated = "created"
+	RenderUpdated = "updated"
+	RenderFailed  = "failed"
+)
+
+// Redacted replaces the values read from Secrets.
+const Redacted = "[redacted]"
+
+// Record is one line of the audit log.
+type Record struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	// RequestUID is the uid of the admission request.
+	RequestUID string                     `json:"requestUID,omitempty"`
+	UserInfo   *authenticationv1.UserInfo `json:"userInfo,omitempty"`
+	Operation  string                     `json:"operation,omitempty"`
+	DryRun     bool                       `json:"dryRun,omitempty"`
+	Namespace  string                     `json:"namespace"`
+	Pod        string                     `json:"pod,omitempty"`
+	Template   string                     `json:"template,omitempty"`
+	// TemplateGeneration is the revision of the template the decision or render used.
+	TemplateGeneration int64  `json:"templateGeneration,omitempty"`
+	CMState            string `json:"cmstate,omitempty"`
+	ConfigMap          string `json:"configMap,omitempty"`
+	// ValueHash identifies the values captured in the CMState, so the admission and the
+	// renders of one CMState share it. Values read from ConfigMaps and Secrets on render
+	// are not part of it.
+	ValueHash string `json:"valueHash,omitempty"`
+	// Values are the values with the ones read from Secrets redacted.
+	Values  map[string]string `json:"values,omitempty"`
+	Outcome string            `json:"outcome"`
+	Message string            `json:"message,omitempty"`
+}
+
+// Logger writes records to its writer, one JSON line each. A nil Logger drops them.
+type Logger struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewLogger returns a Logger writing to out.
+func NewLogger(out io.Writer) *Logger {
+	return &Logger{out: out}
+}
+
+// Open returns the Logger for the destination: nothing for an empty destination,
+// stdout for "-" or "stdout", and otherwise a file rotated at maxBytes.
+func Open(destination string, maxBytes int64, maxBackups int) (*Logger, error) {
+	switch destination {
+	case "":
+		return nil, nil
+	case "-", "stdout":
+		return NewLogger(os.Stdout), nil
+	}
+	file, err := OpenRotatingFile(destination, maxBytes, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return NewLogger(file), nil
+}
+
+// Log writes the record, stamping it with the current time when it has none. Failing
+// writes are dropped, auditing must not fail admission or reconciliation.
+func (l *Logger) Log(record Record) {
+	if l == nil {
+		return
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.out.Write(append(line, '\n'))
+}
+
+// Hash returns a stable hash of the values.
+func Hash(values map[string]string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		// Encoding the pairs keeps key and value boundaries unambiguous
+		_ = json.NewEncoder(hash).Encode([2]string{key, values[key]})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Redact returns a copy of the values with the ones the template reads from Secrets
+// replaced by Redacted.
+func Redact(cmTemplate *cachev1alpha1.CMTemplate, values map[string]string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	redacted := make(map[string]string, len(values))
+	for key, value := range values {
+		redacted[key] = value
+	}
+	if cmTemplate == nil {
+		return redacted
+	}
+	for _, source := range cmTemplate.Spec.Template.ValueSources {
+		if _, ok := redacted[source.Name]; ok && source.SecretKeyRef != nil {
+			redacted[source.Name] = Redacted
+		}
+	}
+	return redacted
+}
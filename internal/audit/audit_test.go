@@ -0,0 +1,109 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+)
+
+var _ = Describe("Audit", func() {
+	It("should write one JSON line per record", func() {
+		out := &bytes.Buffer{}
+		logger := NewLogger(out)
+		logger.Log(Record{Kind: KindAdmission, Namespace: "default", Outcome: "injected"})
+		logger.Log(Record{Kind: KindRender, Namespace: "default", Outcome: RenderCreated})
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		Expect(lines).To(HaveLen(2))
+		record := Record{}
+		Expect(json.Unmarshal([]byte(lines[1]), &record)).To(Succeed())
+		Expect(record.Kind).To(Equal(KindRender))
+		Expect(record.Time).NotTo(BeZero())
+	})
+
+	It("should drop records without a logger", func() {
+		var logger *Logger
+		Expect(func() { logger.Log(Record{}) }).NotTo(Panic())
+	})
+
+	It("should redact the values read from Secrets", func() {
+		cmTemplate := &cachev1alpha1.CMTemplate{Spec: cachev1alpha1.CMTemplateSpec{
+			Template: cachev1alpha1.Template{ValueSources: []cachev1alpha1.ValueSource{
+				{Name: "token", SecretKeyRef: &corev1.SecretKeySelector{Key: "token"}},
+				{Name: "region", PodLabel: "region"},
+			}},
+		}}
+		values := map[string]string{"token": "s3cr3t", "region": "eu-west-1"}
+
+		Expect(Redact(cmTemplate, values)).To(Equal(map[string]string{"token": Redacted, "region": "eu-west-1"}))
+		Expect(values).To(HaveKeyWithValue("token", "s3cr3t"))
+	})
+
+	It("should hash the values regardless of their order", func() {
+		Expect(Hash(map[string]string{"a": "1", "b": "2"})).To(Equal(Hash(map[string]string{"b": "2", "a": "1"})))
+		Expect(Hash(map[string]string{"a": "1", "b": "2"})).NotTo(Equal(Hash(map[string]string{"a": "12"})))
+		Expect(Hash(nil)).To(BeEmpty())
+	})
+
+	It("should rotate the file and keep a limited number of backups", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "audit.log")
+		file, err := OpenRotatingFile(path, 10, 2)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(file.Close)
+
+		for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+			_, err := file.Write([]byte(line))
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Expect(os.ReadFile(path)).To(BeEquivalentTo("fourth\n"))
+		Expect(os.ReadFile(path + ".1")).To(BeEquivalentTo("third\n"))
+		Expect(os.ReadFile(path + ".2")).To(BeEquivalentTo("second\n"))
+		Expect(path + ".3").NotTo(BeAnExistingFile())
+	})
+
+	It("should keep writing to the file when rotating it fails", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "audit.log")
+		file, err := OpenRotatingFile(path, 10, 1)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(file.Close)
+
+		// A directory in the way of the backup makes the rename fail
+		Expect(os.MkdirAll(filepath.Join(path+".1", "blocked"), 0o700)).To(Succeed())
+		_, err = file.Write([]byte("first\n"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.Write([]byte("second\n"))
+		Expect(err).To(MatchError(ContainSubstring("rotating audit log")))
+		Expect(os.ReadFile(path)).To(BeEquivalentTo("first\nsecond\n"))
+
+		Expect(os.RemoveAll(path + ".1")).To(Succeed())
+		_, err = file.Write([]byte("third\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.ReadFile(path)).To(BeEquivalentTo("third\n"))
+		Expect(os.ReadFile(path + ".1")).To(BeEquivalentTo("first\nsecond\n"))
+	})
+})
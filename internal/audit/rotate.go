@@ -0,0 +1,127 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is a file that is rotated once it grows beyond MaxBytes. Rotated files
+// get a numbered suffix, path.1 being the most recent, and only MaxBackups are kept.
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// OpenRotatingFile opens the file at path for appending. A maxBytes of zero or less
+// disables rotation.
+func OpenRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write implements io.Writer. Writes are never split over two files. When rotating
+// fails the file keeps growing, the rotation is retried on the next write.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var rotateErr error
+	if f.file == nil || (f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes) {
+		rotateErr = f.rotate()
+	}
+	if f.file == nil {
+		return 0, rotateErr
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, errors.Join(rotateErr, err)
+}
+
+// Close closes the current file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// rotate shifts the backups up by one, dropping the oldest, and starts a new file.
+// When that fails the file at path is reopened, so writes never go to a closed file.
+// The file is nil when neither could be opened.
+func (f *RotatingFile) rotate() error {
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	if err == nil {
+		err = f.shift()
+	}
+	if err != nil {
+		return errors.Join(fmt.Errorf("rotating audit log: %w", err), f.open())
+	}
+	return f.open()
+}
+
+// shift moves the file at path to the first backup, or removes it without backups.
+func (f *RotatingFile) shift() error {
+	if f.maxBackups <= 0 {
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	_ = os.Remove(backupName(f.path, f.maxBackups))
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(backupName(f.path, i), backupName(f.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(f.path, backupName(f.path, 1))
+}
+
+func backupName(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
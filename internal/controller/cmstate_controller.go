@@ -19,30 +19,52 @@ package controller
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/go-logr/logr"
 	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	"github.com/stollenaar/cmstate-injector-operator/internal/audit"
+	"github.com/stollenaar/cmstate-injector-operator/internal/health"
+	"github.com/stollenaar/cmstate-injector-operator/internal/metrics"
+	"github.com/stollenaar/cmstate-injector-operator/internal/tracing"
+	"github.com/stollenaar/cmstate-injector-operator/internal/workload"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Definitions to manage status conditions
+// customWorkloadResync is how often CMStates with custom controller resources in their audience are reconciled.
+const customWorkloadResync = 5 * time.Minute
+
+// conflictRequeue is how soon a CMState whose audience changed while it was being removed is reconciled again.
+const conflictRequeue = time.Second
+
 const (
-	// typeAvailableCMState represents the status of the ConfigMap reconciliation
-	typeAvailableCMState = "Available"
+	// cmTemplateIndex indexes CMStates by the name of their CMTemplate. The field is
+	// selectable, so lists outside the cache select by it too.
+	cmTemplateIndex = "spec.cmtemplate"
+	// valueRefIndex indexes CMTemplates by the ConfigMaps and Secrets their values are read from, see valueRef.
+	valueRefIndex = "spec.template.valueSources.ref"
 )
 
 // CMStateReconciler reconciles a CMState object
@@ -50,23 +72,37 @@ type CMStateReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+	// APIReader reads the resources of custom controllers in the audience, which are not cached.
+	APIReader client.Reader
+	// Audit receives every render that changed or failed to change a ConfigMap, it may be nil.
+	Audit *audit.Logger
+	// Watchdog tracks the reconciles for the liveness check, it may be nil.
+	Watchdog *health.Watchdog
 }
 
 //+kubebuilder:rbac:groups=cache.spicedelver.me,resources=cmstates,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=cache.spicedelver.me,resources=cmstates/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=cache.spicedelver.me,resources=cmstates/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.14.1/pkg/reconcile
-func (r *CMStateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *CMStateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "CMStateReconciler.Reconcile", trace.WithAttributes(
+		attribute.String("k8s.namespace", req.Namespace),
+		attribute.String("k8s.name", req.Name),
+	))
+	defer func() { tracing.End(span, err) }()
+	defer r.Watchdog.Track()()
 	log := log.FromContext(ctx)
 
 	cmState := &cachev1alpha1.CMState{}
-	err := r.Get(ctx, req.NamespacedName, cmState)
+	err = r.Get(ctx, req.NamespacedName, cmState)
 	if err != nil {
 		// If this is not nil we are already tracking one. So in this case we need to add to the audience
 		if apierrors.IsNotFound(err) {
@@ -77,6 +113,11 @@ func (r *CMStateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		log.Error(err, "Failed to get cmstate")
 		return ctrl.Result{}, err
 	}
+	// Connects the reconcile to the admission that created the CMState
+	if link, ok := tracing.ParentLink(cmState); ok {
+		span.AddLink(link)
+	}
+	original := cmState.Status.DeepCopy()
 
 	// Check if the CmState instance is marked to be deleted, which is
 	// indicated by the deletion timestamp being set.
@@ -88,50 +129,65 @@ func (r *CMStateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 				Kind:       "ConfigMap",
 			},
 			ObjectMeta: metav1.ObjectMeta{
-				Name: cmState.Spec.Target,
+				Name:      cmState.Spec.Target,
+				Namespace: cmState.GetNamespace(),
 			},
 		}
-		r.Delete(ctx, cm)
+		r.event(cmState, corev1.EventTypeNormal, cachev1alpha1.EventReasonPendingDeletion,
+			fmt.Sprintf("CMState is being deleted, removing ConfigMap %s", cm.Name))
+		if err := r.Delete(ctx, cm); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "Failed to delete tracked ConfigMap")
+			return ctrl.Result{}, err
+		}
+		r.event(templateRef(cmState), corev1.EventTypeNormal, cachev1alpha1.EventReasonCleanedUp,
+			fmt.Sprintf("Removed ConfigMap %s/%s of deleted CMState %s", cm.Namespace, cm.Name, cmState.Name))
 		return ctrl.Result{}, nil
 	}
 
+	if err = r.migrateValues(ctx, cmState); err != nil {
+		log.Error(err, "Failed to migrate CMState values")
+		return ctrl.Result{}, err
+	}
+
 	found := &corev1.ConfigMap{}
 	err = r.Get(ctx, types.NamespacedName{Name: cmState.Spec.Target, Namespace: cmState.Namespace}, found)
 	if cmState.Spec.Target == "" {
-		cm, err := r.configMapForCMState(cmState, ctx, log)
+		cm, source, err := r.configMapForCMState(cmState, ctx, log)
+		observeRender(cmState, cm, err)
 		if err != nil {
 			log.Error(err, "Failed to define new Configmap resource for CMState")
-
-			// The following implementation will update the status
-			meta.SetStatusCondition(&cmState.Status.Conditions, metav1.Condition{Type: typeAvailableCMState,
-				Status: metav1.ConditionFalse, Reason: "Reconciling",
-				Message: fmt.Sprintf("Failed to create Configmap for the custom resource (%s): (%s)", cmState.Name, err)})
-
-			if err := r.Status().Update(ctx, cmState); err != nil {
-				log.Error(err, "Failed to update CMState status")
-				return ctrl.Result{}, err
-			}
-
-			return ctrl.Result{}, err
+			r.auditRender(cmState, nil, nil, audit.RenderFailed, err)
+			return ctrl.Result{}, r.setRenderFailed(ctx, cmState, original, err)
 		}
 		log.Info("Creating a new ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
 		if err = r.Create(ctx, cm); err != nil {
 			log.Error(err, "Failed to create new ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
 			return ctrl.Result{}, err
 		}
+		r.auditRender(cmState, cm, source, audit.RenderCreated, nil)
+		message := fmt.Sprintf("Rendered ConfigMap %s from cmtemplate %s", cm.Name, cmState.Spec.CMTemplate)
+		r.event(cmState, corev1.EventTypeNormal, cachev1alpha1.EventReasonConfigMapCreated, message)
+		r.event(cm, corev1.EventTypeNormal, cachev1alpha1.EventReasonConfigMapCreated, message)
 		cmState.Spec.Target = cm.GetName()
 		err = r.Patch(ctx, cmState, client.Merge)
 		if err != nil {
 			log.Error(err, "Failed to update CMState Audience")
 			return ctrl.Result{}, err
 		}
-		return ctrl.Result{}, nil
+		setCMStateConditions(cmState, nil, "")
+		return ctrl.Result{}, r.updateStatus(ctx, cmState, original)
 	} else if err != nil {
 		log.Error(err, "Failed to get ConfigMap")
 		return ctrl.Result{}, err
 	}
 
-	if len(cmState.Spec.Audience) == 0 {
+	if err = r.releaseWorkloads(ctx, cmState); err != nil {
+		log.Error(err, "Failed to release workloads from CMState Audience")
+		return ctrl.Result{}, err
+	}
+
+	// Static CMStates are maintained by hand and outlive their audience
+	if len(cmState.Spec.Audience) == 0 && !cmState.Spec.Static {
 		cm := &corev1.ConfigMap{
 			TypeMeta: metav1.TypeMeta{
 				APIVersion: "v1",
@@ -142,55 +198,373 @@ func (r *CMStateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 				Namespace: cmState.GetNamespace(),
 			},
 		}
-		err = r.Delete(ctx, cm)
-		if err != nil {
-			log.Error(err, "Failed to delete tracked ConfigMap")
+		r.event(cmState, corev1.EventTypeNormal, cachev1alpha1.EventReasonPendingDeletion,
+			fmt.Sprintf("Audience is empty, removing CMState and ConfigMap %s", cm.Name))
+		setCMStateConditions(cmState, nil, cachev1alpha1.ReasonAudienceEmpty)
+		if err = r.updateStatus(ctx, cmState, original); err != nil {
+			return ctrl.Result{}, err
 		}
-		err = r.Delete(ctx, cmState)
-		if err != nil {
+		// A pod may join the audience after it was read, only delete the CMState that was seen empty
+		err = r.Delete(ctx, cmState, client.Preconditions{UID: &cmState.UID, ResourceVersion: &cmState.ResourceVersion})
+		if apierrors.IsConflict(err) {
+			log.Info("CMState changed while removing it, reconciling it again")
+			return ctrl.Result{RequeueAfter: conflictRequeue}, nil
+		} else if client.IgnoreNotFound(err) != nil {
 			log.Error(err, "Failed to delete CMState")
 			return ctrl.Result{}, err
 		}
+		if err = r.Delete(ctx, cm); client.IgnoreNotFound(err) != nil {
+			// The garbage collector still removes the ConfigMap of the deleted CMState
+			log.Error(err, "Failed to delete tracked ConfigMap")
+			return ctrl.Result{}, err
+		}
+		metrics.ForgetConfigMap(cm.Namespace, cm.Name)
+		r.event(templateRef(cmState), corev1.EventTypeNormal, cachev1alpha1.EventReasonCleanedUp,
+			fmt.Sprintf("Removed CMState %s/%s and its ConfigMap %s, its audience was empty", cmState.Namespace, cmState.Name, cm.Name))
+		return ctrl.Result{}, nil
 	}
 
+	// Re-render the tracked ConfigMap so changes to the template or its referenced values are picked up
+	cm, source, err := r.configMapForCMState(cmState, ctx, log)
+	observeRender(cmState, cm, err)
+	if err != nil {
+		log.Error(err, "Failed to render Configmap for CMState")
+		r.auditRender(cmState, nil, nil, audit.RenderFailed, err)
+		return ctrl.Result{}, r.setRenderFailed(ctx, cmState, original, err)
+	}
+	if !equality.Semantic.DeepEqual(found.Data, cm.Data) {
+		log.Info("Updating rendered ConfigMap", "ConfigMap.Namespace", found.Namespace, "ConfigMap.Name", found.Name)
+		found.Data = cm.Data
+		if err = r.Update(ctx, found); err != nil {
+			log.Error(err, "Failed to update ConfigMap", "ConfigMap.Namespace", found.Namespace, "ConfigMap.Name", found.Name)
+			return ctrl.Result{}, err
+		}
+		metrics.DriftCorrections.WithLabelValues(cmState.Spec.CMTemplate).Inc()
+		r.auditRender(cmState, found, source, audit.RenderUpdated, nil)
+		message := fmt.Sprintf("Re-rendered ConfigMap %s from cmtemplate %s", found.Name, cmState.Spec.CMTemplate)
+		r.event(cmState, corev1.EventTypeNormal, cachev1alpha1.EventReasonConfigMapRendered, message)
+		r.event(found, corev1.EventTypeNormal, cachev1alpha1.EventReasonConfigMapRendered, message)
+	}
+	setCMStateConditions(cmState, nil, "")
+	if err = r.updateStatus(ctx, cmState, original); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Resources of custom controllers are not watched, check on them periodically
+	if slices.ContainsFunc(cmState.Spec.Audience, workload.IsCustom) {
+		return ctrl.Result{RequeueAfter: customWorkloadResync}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
+// releaseWorkloads removes the workloads that no longer need the CMState from its audience.
+func (r *CMStateReconciler) releaseWorkloads(ctx context.Context, cmState *cachev1alpha1.CMState) error {
+	resolver := &workload.Resolver{Client: r.Client, APIReader: r.APIReader}
+	original := cmState.DeepCopy()
+	audience := make([]cachev1alpha1.CMAudience, 0, len(cmState.Spec.Audience))
+	for _, member := range cmState.Spec.Audience {
+		alive, err := resolver.Alive(ctx, cmState.Namespace, member)
+		if err != nil {
+			return err
+		}
+		if alive {
+			audience = append(audience, member)
+			continue
+		}
+		log.FromContext(ctx).Info("Releasing workload from CMState Audience", "Kind", member.Kind, "Name", member.Name)
+	}
+	if len(audience) == len(original.Spec.Audience) {
+		return nil
+	}
+	cmState.Spec.Audience = audience
+	if err := r.Patch(ctx, cmState, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{})); err != nil {
+		return err
+	}
+	r.event(cmState, corev1.EventTypeNormal, cachev1alpha1.EventReasonAudienceChanged,
+		fmt.Sprintf("Released %d workloads, audience has %d members", len(original.Spec.Audience)-len(audience), len(audience)))
+	return nil
+}
+
+// migrateValues moves the replacement values that older versions stored in the
+// CMState labels into spec.values.
+func (r *CMStateReconciler) migrateValues(ctx context.Context, cmState *cachev1alpha1.CMState) error {
+	cmTemplate := &cachev1alpha1.CMTemplate{}
+	if err := r.Get(ctx, types.NamespacedName{Name: cmState.Spec.CMTemplate}, cmTemplate); err != nil {
+		// Rendering reports the missing template
+		return client.IgnoreNotFound(err)
+	}
+
+	original := cmState.DeepCopy()
+	for annotation := range cmTemplate.Spec.Template.AnnotationReplace {
+		value, ok := cmState.Labels[annotation]
+		if !ok {
+			continue
+		}
+		if _, exists := cmState.Spec.Values[annotation]; !exists {
+			if cmState.Spec.Values == nil {
+				cmState.Spec.Values = make(map[string]string)
+			}
+			cmState.Spec.Values[annotation] = value
+		}
+		delete(cmState.Labels, annotation)
+	}
+
+	if equality.Semantic.DeepEqual(original, cmState) {
+		return nil
+	}
+	log.FromContext(ctx).Info("Migrating CMState values into spec", "CMState.Namespace", cmState.Namespace, "CMState.Name", cmState.Name)
+	return r.Patch(ctx, cmState, client.MergeFrom(original))
+}
+
+// observeRender records the outcome of rendering the ConfigMap of the CMState.
+func observeRender(cmState *cachev1alpha1.CMState, cm *corev1.ConfigMap, err error) {
+	if err != nil {
+		metrics.ObserveRender(cmState.Spec.CMTemplate, nil, "", "", err)
+		return
+	}
+	metrics.ObserveRender(cmState.Spec.CMTemplate, cm.Data, cm.Namespace, cm.Name, nil)
+}
+
+// auditRender writes a render of the ConfigMap of the CMState to the audit log. The
+// ConfigMap and its source are nil when the render failed.
+func (r *CMStateReconciler) auditRender(cmState *cachev1alpha1.CMState, cm *corev1.ConfigMap, source *renderSource, outcome string, err error) {
+	if r.Audit == nil {
+		return
+	}
+	record := audit.Record{
+		Kind:      audit.KindRender,
+		Namespace: cmState.Namespace,
+		Template:  cmState.Spec.CMTemplate,
+		CMState:   cmState.Name,
+		ConfigMap: cmState.Spec.Target,
+		Outcome:   outcome,
+	}
+	if cm != nil {
+		record.ConfigMap = cm.Name
+	}
+	if source != nil {
+		record.TemplateGeneration = source.cmTemplate.Generation
+		record.ValueHash = audit.Hash(cmState.Spec.Values)
+		record.Values = audit.Redact(source.cmTemplate, source.values)
+	}
+	if err != nil {
+		record.Message = err.Error()
+	}
+	r.Audit.Log(record)
+}
+
+// setRenderFailed records a failed render on the CMState status and returns the original error.
+func (r *CMStateReconciler) setRenderFailed(ctx context.Context, cmState *cachev1alpha1.CMState, original *cachev1alpha1.CMStateStatus, renderErr error) error {
+	message := fmt.Sprintf("Failed to render the ConfigMap of CMState %s/%s: %s", cmState.Namespace, cmState.Name, renderErr)
+	r.event(cmState, corev1.EventTypeWarning, cachev1alpha1.EventReasonRenderFailed, message)
+	r.event(templateRef(cmState), corev1.EventTypeWarning, cachev1alpha1.EventReasonRenderFailed, message)
+
+	setCMStateConditions(cmState, renderErr, "")
+	if err := r.updateStatus(ctx, cmState, original); err != nil {
+		return err
+	}
+	return renderErr
+}
+
+// updateStatus writes the status of the CMState when it differs from the original one.
+func (r *CMStateReconciler) updateStatus(ctx context.Context, cmState *cachev1alpha1.CMState, original *cachev1alpha1.CMStateStatus) error {
+	if equality.Semantic.DeepEqual(original, &cmState.Status) {
+		return nil
+	}
+	if err := r.Status().Update(ctx, cmState); client.IgnoreNotFound(err) != nil {
+		log.FromContext(ctx).Error(err, "Failed to update CMState status")
+		return err
+	}
+	return nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *CMStateReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	indexer := mgr.GetFieldIndexer()
+	if err := indexer.IndexField(context.Background(), &cachev1alpha1.CMState{}, cmTemplateIndex, indexCMTemplate); err != nil {
+		return err
+	}
+	if err := indexer.IndexField(context.Background(), &cachev1alpha1.CMTemplate{}, valueRefIndex, indexValueRefs); err != nil {
+		return err
+	}
+
+	// ConfigMaps and Secrets are only watched by their metadata, so the cache does not
+	// hold the data of every one of them in the cluster. The manager reads them from the
+	// API server, see the cache options in cmd/main.go.
+	controllerBuilder := ctrl.NewControllerManagedBy(mgr).
 		For(&cachev1alpha1.CMState{}).
 		Named("CMStateController").
-		Owns(&corev1.ConfigMap{}).
-		Complete(r)
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.cmStatesForConfigMap), builder.OnlyMetadata).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.cmStatesForValueRef(kindSecret)), builder.OnlyMetadata)
+	for _, obj := range workload.Objects() {
+		controllerBuilder = controllerBuilder.Watches(obj, handler.EnqueueRequestsFromMapFunc(r.cmStatesForWorkload))
+	}
+	return controllerBuilder.Complete(r)
+}
+
+// indexCMTemplate returns the CMTemplate of a CMState for the cmTemplateIndex.
+func indexCMTemplate(obj client.Object) []string {
+	cmState, ok := obj.(*cachev1alpha1.CMState)
+	if !ok || cmState.Spec.CMTemplate == "" {
+		return nil
+	}
+	return []string{cmState.Spec.CMTemplate}
+}
+
+// indexValueRefs returns the ConfigMaps and Secrets a CMTemplate reads values from for the valueRefIndex.
+func indexValueRefs(obj client.Object) []string {
+	cmTemplate, ok := obj.(*cachev1alpha1.CMTemplate)
+	if !ok {
+		return nil
+	}
+	var refs []string
+	for _, source := range cmTemplate.Spec.Template.ValueSources {
+		switch {
+		case source.ConfigMapKeyRef != nil:
+			refs = append(refs, valueRef(kindConfigMap, source.ConfigMapKeyRef.Name))
+		case source.SecretKeyRef != nil:
+			refs = append(refs, valueRef(kindSecret, source.SecretKeyRef.Name))
+		}
+	}
+	return refs
+}
+
+// Kinds of the objects values are read from.
+const (
+	kindConfigMap = "ConfigMap"
+	kindSecret    = "Secret"
+)
+
+// valueRef is the valueRefIndex key of a ConfigMap or Secret.
+func valueRef(kind, name string) string {
+	return kind + "/" + name
+}
+
+// cmStatesForWorkload maps a workload to the CMStates in its namespace that have it in their audience.
+func (r *CMStateReconciler) cmStatesForWorkload(ctx context.Context, obj client.Object) []reconcile.Request {
+	cmStates := &cachev1alpha1.CMStateList{}
+	if err := r.List(ctx, cmStates, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list CMStates for workload", "Namespace", obj.GetNamespace())
+		return nil
+	}
+
+	member := cachev1alpha1.CMAudience{Kind: workload.KindOf(obj), Name: obj.GetName()}
+	var requests []reconcile.Request
+	for _, cmState := range cmStates.Items {
+		if slices.Contains(cmState.Spec.Audience, member) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: cmState.Namespace, Name: cmState.Name},
+			})
+		}
+	}
+	return requests
+}
+
+// cmStatesForConfigMap maps a ConfigMap to the CMState rendering it and to the CMStates
+// in its namespace whose template reads a value from it.
+func (r *CMStateReconciler) cmStatesForConfigMap(ctx context.Context, obj client.Object) []reconcile.Request {
+	requests := r.cmStatesForValueRef(kindConfigMap)(ctx, obj)
+	owner := metav1.GetControllerOf(obj)
+	if owner != nil && owner.Kind == "CMState" && owner.APIVersion == cachev1alpha1.GroupVersion.String() {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: owner.Name},
+		})
+	}
+	return requests
+}
+
+// cmStatesForValueRef maps a ConfigMap or Secret to the CMStates in its namespace
+// whose template reads a value from it.
+func (r *CMStateReconciler) cmStatesForValueRef(kind string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		log := log.FromContext(ctx)
+
+		cmTemplates := &cachev1alpha1.CMTemplateList{}
+		if err := r.List(ctx, cmTemplates, client.MatchingFields{valueRefIndex: valueRef(kind, obj.GetName())}); err != nil {
+			log.Error(err, "Failed to list CMTemplates for value reference", "Kind", kind, "Name", obj.GetName())
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for _, cmTemplate := range cmTemplates.Items {
+			cmStates := &cachev1alpha1.CMStateList{}
+			if err := r.List(ctx, cmStates, client.InNamespace(obj.GetNamespace()),
+				client.MatchingFields{cmTemplateIndex: cmTemplate.Name}); err != nil {
+				log.Error(err, "Failed to list CMStates for value reference", "Namespace", obj.GetNamespace())
+				return nil
+			}
+			for _, cmState := range cmStates.Items {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Namespace: cmState.Namespace, Name: cmState.Name},
+				})
+			}
+		}
+		return requests
+	}
 }
 
-// configMapForCMState returns a CMState Deployment object
+// renderSource is what a ConfigMap was rendered from.
+type renderSource struct {
+	cmTemplate *cachev1alpha1.CMTemplate
+	// values holds the values of the annotations and value sources, by name.
+	values map[string]string
+}
+
+// RenderConfigMap renders the ConfigMap of the CMState the way the reconciler does,
+// without writing it. It lets the operator be previewed, e.g. from CI.
+func (r *CMStateReconciler) RenderConfigMap(ctx context.Context, cmState *cachev1alpha1.CMState) (*corev1.ConfigMap, error) {
+	cm, _, err := r.configMapForCMState(cmState, ctx, log.FromContext(ctx))
+	return cm, err
+}
+
+// configMapForCMState returns a CMState Deployment object, together with what it was rendered from
 func (r *CMStateReconciler) configMapForCMState(
-	cmstate *cachev1alpha1.CMState, ctx context.Context, log logr.Logger) (*corev1.ConfigMap, error) {
+	cmstate *cachev1alpha1.CMState, ctx context.Context, log logr.Logger) (*corev1.ConfigMap, *renderSource, error) {
 	cmTemplate := &cachev1alpha1.CMTemplate{}
 	err := r.Get(ctx, types.NamespacedName{
 		Name: cmstate.Spec.CMTemplate,
 	}, cmTemplate)
 	if err != nil {
 		log.Error(err, "Error fetching cmTemplate")
-		return nil, err
+		return nil, nil, err
+	}
+
+	if cmTemplate.RestrictsNamespaces() {
+		namespace := &corev1.Namespace{}
+		if err := r.Get(ctx, types.NamespacedName{Name: cmstate.GetNamespace()}, namespace); err != nil {
+			log.Error(err, "Error fetching namespace")
+			return nil, nil, err
+		}
+		allowed, reason, err := cmTemplate.AllowsNamespace(namespace)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !allowed {
+			return nil, nil, errors.New(reason)
+		}
 	}
 
-	labels := cmstate.GetLabels()
+	values, err := r.resolveValues(ctx, cmstate, cmTemplate)
+	if err != nil {
+		log.Error(err, "Error resolving cmTemplate values")
+		return nil, nil, err
+	}
 
 	data := make(map[string]string)
 
 	for key, template := range cmTemplate.Spec.Template.CMTemplate {
 		for annotation, templateKey := range cmTemplate.Spec.Template.AnnotationReplace {
-			template = strings.ReplaceAll(template, templateKey, labels[annotation])
+			template = strings.ReplaceAll(template, templateKey, cmstate.Spec.Values[annotation])
+		}
+		for _, source := range cmTemplate.Spec.Template.ValueSources {
+			template = strings.ReplaceAll(template, source.Placeholder, values[source.Name])
 		}
 		data[key] = template
 	}
 	// configReplace := strings.NewReplacer("${exit_after_auth}", "false", "${internal_role_name}", labels["internal-role"], "${aws_role_name}", labels["aws-role"])
 	// configInitReplace := strings.NewReplacer("${exit_after_auth}", "true", "${internal_role_name}", labels["internal-role"], "${aws_role_name}", labels["aws-role"])
 
-	return &corev1.ConfigMap{
+	cm := &corev1.ConfigMap{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "v1",
 			Kind:       "ConfigMap",
@@ -204,5 +578,58 @@ func (r *CMStateReconciler) configMapForCMState(
 		// 	"config.hcl":      configReplace.Replace(agentTemplate),
 		// 	"config-init.hcl": configInitReplace.Replace(agentTemplate),
 		// },
-	}, nil
+	}
+	if err := ctrl.SetControllerReference(cmstate, cm, r.Scheme); err != nil {
+		return nil, nil, err
+	}
+
+	source := &renderSource{cmTemplate: cmTemplate, values: make(map[string]string)}
+	for annotation := range cmTemplate.Spec.Template.AnnotationReplace {
+		source.values[annotation] = cmstate.Spec.Values[annotation]
+	}
+	for name, value := range values {
+		source.values[name] = value
+	}
+	return cm, source, nil
+}
+
+// resolveValues resolves the value sources of the template, keyed by value source name.
+// Values captured from the pod at admission are read back from the CMState spec,
+// ConfigMap and Secret references are read from the CMState's namespace.
+func (r *CMStateReconciler) resolveValues(
+	ctx context.Context, cmstate *cachev1alpha1.CMState, cmTemplate *cachev1alpha1.CMTemplate) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, source := range cmTemplate.Spec.Template.ValueSources {
+		switch {
+		case source.FromPod():
+			values[source.Name] = cmstate.Spec.Values[source.Name]
+		case source.Value != nil:
+			values[source.Name] = *source.Value
+		case source.ConfigMapKeyRef != nil:
+			ref := source.ConfigMapKeyRef
+			cm := &corev1.ConfigMap{}
+			err := r.Get(ctx, types.NamespacedName{Namespace: cmstate.Namespace, Name: ref.Name}, cm)
+			if err != nil && !(apierrors.IsNotFound(err) && ptr.Deref(ref.Optional, false)) {
+				return nil, fmt.Errorf("fetching configmap %q for value %q: %w", ref.Name, source.Name, err)
+			}
+			value, ok := cm.Data[ref.Key]
+			if !ok && err == nil && !ptr.Deref(ref.Optional, false) {
+				return nil, fmt.Errorf("configmap %q has no key %q for value %q", ref.Name, ref.Key, source.Name)
+			}
+			values[source.Name] = value
+		case source.SecretKeyRef != nil:
+			ref := source.SecretKeyRef
+			secret := &corev1.Secret{}
+			err := r.Get(ctx, types.NamespacedName{Namespace: cmstate.Namespace, Name: ref.Name}, secret)
+			if err != nil && !(apierrors.IsNotFound(err) && ptr.Deref(ref.Optional, false)) {
+				return nil, fmt.Errorf("fetching secret %q for value %q: %w", ref.Name, source.Name, err)
+			}
+			value, ok := secret.Data[ref.Key]
+			if !ok && err == nil && !ptr.Deref(ref.Optional, false) {
+				return nil, fmt.Errorf("secret %q has no key %q for value %q", ref.Name, ref.Key, source.Name)
+			}
+			values[source.Name] = string(value)
+		}
+	}
+	return values, nil
 }
@@ -17,17 +17,32 @@ limitations under the License.
 package controller
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	"github.com/stollenaar/cmstate-injector-operator/internal/audit"
+	"github.com/stollenaar/cmstate-injector-operator/internal/metrics"
 )
 
 var _ = Describe("CMState Controller", func() {
@@ -81,4 +96,561 @@ var _ = Describe("CMState Controller", func() {
 			// Example: If you expect a certain status condition after reconciliation, verify it here.
 		})
 	})
+
+	Context("When rendering values from other sources", func() {
+		const resourceName = "test-values"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("creating the template, the referenced configmap and the cmstate")
+			Expect(k8sClient.Create(ctx, &cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName},
+				Spec: cachev1alpha1.CMTemplateSpec{
+					Template: cachev1alpha1.Template{
+						CMTemplate:       map[string]string{"config": "${region}/${role}/${image}"},
+						TargetAnnotation: "test/target",
+						ValueSources: []cachev1alpha1.ValueSource{
+							{Name: "region", Placeholder: "${region}", Value: ptr.To("eu-west-1")},
+							{Name: "role", Placeholder: "${role}", ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+								LocalObjectReference: corev1.LocalObjectReference{Name: resourceName + "-ref"},
+								Key:                  "role",
+							}},
+							{Name: "image", Placeholder: "${image}", PodField: "spec.containers[app].image"},
+						},
+					},
+				},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName + "-ref", Namespace: "default"},
+				Data:       map[string]string{"role": "reader"},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &cachev1alpha1.CMState{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: cachev1alpha1.CMStateSpec{
+					Audience:   []cachev1alpha1.CMAudience{{Kind: "Pod", Name: "app-"}},
+					CMTemplate: resourceName,
+					Values:     map[string]string{"image": "nginx:1.27"},
+				},
+			})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.Delete(ctx, &cachev1alpha1.CMState{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+			})).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName + "-ref", Namespace: "default"},
+			})).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName},
+			})).To(Succeed())
+		})
+
+		It("should render and re-render the configmap", func() {
+			controllerReconciler := &CMStateReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			By("Reconciling the created resource")
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			rendered := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, rendered)).To(Succeed())
+			Expect(rendered.Data).To(HaveKeyWithValue("config", "eu-west-1/reader/nginx:1.27"))
+
+			cmState := &cachev1alpha1.CMState{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cmState)).To(Succeed())
+			Expect(cmState.Status.ObservedGeneration).To(Equal(cmState.Generation))
+			ready := meta.FindStatusCondition(cmState.Status.Conditions, cachev1alpha1.ConditionReady)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Status).To(Equal(metav1.ConditionTrue))
+			Expect(ready.ObservedGeneration).To(Equal(cmState.Generation))
+			Expect(meta.IsStatusConditionTrue(cmState.Status.Conditions, cachev1alpha1.ConditionInjected)).To(BeTrue())
+			Expect(meta.IsStatusConditionFalse(cmState.Status.Conditions, cachev1alpha1.ConditionDegraded)).To(BeTrue())
+
+			By("Breaking the referenced configmap")
+			ref := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-ref", Namespace: "default"}, ref)).To(Succeed())
+			delete(ref.Data, "role")
+			Expect(k8sClient.Update(ctx, ref)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).To(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cmState)).To(Succeed())
+			Expect(meta.IsStatusConditionFalse(cmState.Status.Conditions, cachev1alpha1.ConditionReady)).To(BeTrue())
+			Expect(meta.IsStatusConditionTrue(cmState.Status.Conditions, cachev1alpha1.ConditionDegraded)).To(BeTrue())
+			Expect(meta.FindStatusCondition(cmState.Status.Conditions, cachev1alpha1.ConditionRendered).Reason).
+				To(Equal(cachev1alpha1.ReasonRenderFailed))
+
+			By("Changing the referenced configmap")
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-ref", Namespace: "default"}, ref)).To(Succeed())
+			ref.Data["role"] = "writer"
+			Expect(k8sClient.Update(ctx, ref)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, rendered)).To(Succeed())
+			Expect(rendered.Data).To(HaveKeyWithValue("config", "eu-west-1/writer/nginx:1.27"))
+		})
+	})
+
+	Context("When the values are stored by an older version", func() {
+		const resourceName = "test-migrate"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			Expect(k8sClient.Create(ctx, &cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName},
+				Spec: cachev1alpha1.CMTemplateSpec{
+					Template: cachev1alpha1.Template{
+						AnnotationReplace: map[string]string{"aws-role": "${aws_role}"},
+						CMTemplate:        map[string]string{"config": "role=${aws_role}"},
+						TargetAnnotation:  "test/target",
+					},
+				},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &cachev1alpha1.CMState{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+					Labels:    map[string]string{"aws-role": "reader"},
+				},
+				Spec: cachev1alpha1.CMStateSpec{
+					Audience:   []cachev1alpha1.CMAudience{{Kind: "Pod", Name: "app-"}},
+					CMTemplate: resourceName,
+				},
+			})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.Delete(ctx, &cachev1alpha1.CMState{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+			})).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName},
+			})).To(Succeed())
+		})
+
+		It("should move the label values into the spec", func() {
+			recorder := record.NewFakeRecorder(10)
+			controllerReconciler := &CMStateReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			migrated := &cachev1alpha1.CMState{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, migrated)).To(Succeed())
+			Expect(migrated.Spec.Values).To(HaveKeyWithValue("aws-role", "reader"))
+			Expect(migrated.Labels).NotTo(HaveKey("aws-role"))
+
+			rendered := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, rendered)).To(Succeed())
+			Expect(rendered.Data).To(HaveKeyWithValue("config", "role=reader"))
+			Expect(recorder.Events).To(Receive(ContainSubstring(cachev1alpha1.EventReasonConfigMapCreated)))
+		})
+	})
+
+	Context("When reconciling a static resource", func() {
+		const resourceName = "test-static"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			Expect(k8sClient.Create(ctx, &cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName},
+				Spec: cachev1alpha1.CMTemplateSpec{
+					Template: cachev1alpha1.Template{
+						AnnotationReplace: map[string]string{"aws-role": "${aws_role}"},
+						CMTemplate:        map[string]string{"config": "role=${aws_role}"},
+						TargetAnnotation:  "test/target",
+					},
+				},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &cachev1alpha1.CMState{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+				Spec: cachev1alpha1.CMStateSpec{
+					CMTemplate: resourceName,
+					Static:     true,
+					Values:     map[string]string{"aws-role": "arn:aws:iam::123456789012:role/static"},
+				},
+			})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.Delete(ctx, &cachev1alpha1.CMState{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+			})).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName},
+			})).To(Succeed())
+		})
+
+		It("should render the configmap and keep the cmstate without audience", func() {
+			controllerReconciler := &CMStateReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			for range 2 {
+				_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &cachev1alpha1.CMState{})).To(Succeed())
+			rendered := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, rendered)).To(Succeed())
+			Expect(rendered.Data).To(HaveKeyWithValue("config", "role=arn:aws:iam::123456789012:role/static"))
+		})
+	})
+
+	Context("When the audience holds workloads", func() {
+		const resourceName = "test-workloads"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			Expect(k8sClient.Create(ctx, &cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName},
+				Spec: cachev1alpha1.CMTemplateSpec{
+					Template: cachev1alpha1.Template{
+						CMTemplate:       map[string]string{"config": "static"},
+						TargetAnnotation: "test/target",
+					},
+				},
+			})).To(Succeed())
+			labels := map[string]string{"app": resourceName}
+			Expect(k8sClient.Create(ctx, &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: labels},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}},
+					},
+				},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &cachev1alpha1.CMState{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+				Spec: cachev1alpha1.CMStateSpec{
+					Audience: []cachev1alpha1.CMAudience{
+						{Kind: "Deployment", Name: resourceName},
+						{Kind: "StatefulSet", Name: "gone"},
+					},
+					CMTemplate: resourceName,
+				},
+			})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.Delete(ctx, &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+			})).To(Succeed())
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, &cachev1alpha1.CMState{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+			}))).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName},
+			})).To(Succeed())
+		})
+
+		It("should release deleted workloads and keep live ones", func() {
+			controllerReconciler := &CMStateReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			for range 2 {
+				_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			cmState := &cachev1alpha1.CMState{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cmState)).To(Succeed())
+			Expect(cmState.Spec.Audience).To(ConsistOf(cachev1alpha1.CMAudience{Kind: "Deployment", Name: resourceName}))
+
+			By("Scaling the deployment to zero")
+			deployment := &appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, deployment)).To(Succeed())
+			deployment.Spec.Replicas = ptr.To[int32](0)
+			Expect(k8sClient.Update(ctx, deployment)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			err = k8sClient.Get(ctx, typeNamespacedName, cmState)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Context("When a referenced ConfigMap or Secret changes", func() {
+		ctx := context.Background()
+
+		It("should map it to the cmstates of the templates reading it", func() {
+			reader := &cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "reader"},
+				Spec: cachev1alpha1.CMTemplateSpec{Template: cachev1alpha1.Template{ValueSources: []cachev1alpha1.ValueSource{
+					{Name: "role", ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "shared"}, Key: "role",
+					}},
+					{Name: "token", SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "shared"}, Key: "token",
+					}},
+				}}},
+			}
+			cmState := func(name, namespace, template string) *cachev1alpha1.CMState {
+				return &cachev1alpha1.CMState{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+					Spec:       cachev1alpha1.CMStateSpec{CMTemplate: template},
+				}
+			}
+			controllerReconciler := &CMStateReconciler{
+				Client: fake.NewClientBuilder().
+					WithScheme(scheme.Scheme).
+					WithIndex(&cachev1alpha1.CMState{}, cmTemplateIndex, indexCMTemplate).
+					WithIndex(&cachev1alpha1.CMTemplate{}, valueRefIndex, indexValueRefs).
+					WithObjects(
+						reader,
+						&cachev1alpha1.CMTemplate{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+						cmState("cmstate-reader", "default", "reader"),
+						cmState("cmstate-reader", "elsewhere", "reader"),
+						cmState("cmstate-other", "default", "other"),
+					).
+					Build(),
+			}
+
+			shared := metav1.ObjectMeta{Name: "shared", Namespace: "default"}
+			expected := reconcile.Request{NamespacedName: types.NamespacedName{Name: "cmstate-reader", Namespace: "default"}}
+			Expect(controllerReconciler.cmStatesForConfigMap(ctx, &metav1.PartialObjectMetadata{ObjectMeta: shared})).
+				To(ConsistOf(expected))
+			Expect(controllerReconciler.cmStatesForValueRef(kindSecret)(ctx, &metav1.PartialObjectMetadata{ObjectMeta: shared})).
+				To(ConsistOf(expected))
+			Expect(controllerReconciler.cmStatesForValueRef(kindSecret)(ctx, &metav1.PartialObjectMetadata{
+				ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "default"},
+			})).To(BeEmpty())
+
+			By("Mapping a rendered ConfigMap to its cmstate")
+			rendered := metav1.ObjectMeta{Name: "cmstate-other", Namespace: "default", OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: cachev1alpha1.GroupVersion.String(), Kind: "CMState", Name: "cmstate-other", Controller: ptr.To(true),
+			}}}
+			Expect(controllerReconciler.cmStatesForConfigMap(ctx, &metav1.PartialObjectMetadata{ObjectMeta: rendered})).
+				To(ConsistOf(reconcile.Request{NamespacedName: types.NamespacedName{Name: "cmstate-other", Namespace: "default"}}))
+		})
+	})
+
+	Context("When recording events", func() {
+		const resourceName = "test-events"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{Name: resourceName, Namespace: "default"}
+
+		var (
+			recorder  *record.FakeRecorder
+			deleteErr error
+		)
+
+		newReconciler := func(cmState *cachev1alpha1.CMState, allowedNamespaces ...string) *CMStateReconciler {
+			recorder = record.NewFakeRecorder(10)
+			deleteErr = nil
+			return &CMStateReconciler{
+				Client: fake.NewClientBuilder().
+					WithScheme(scheme.Scheme).
+					WithStatusSubresource(&cachev1alpha1.CMState{}).
+					WithInterceptorFuncs(interceptor.Funcs{
+						Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+							if _, ok := obj.(*corev1.ConfigMap); ok && deleteErr != nil {
+								return deleteErr
+							}
+							return c.Delete(ctx, obj, opts...)
+						},
+					}).
+					WithObjects(
+						&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+						&cachev1alpha1.CMTemplate{
+							ObjectMeta: metav1.ObjectMeta{Name: resourceName},
+							Spec: cachev1alpha1.CMTemplateSpec{
+								Template:          cachev1alpha1.Template{CMTemplate: map[string]string{"config": "static"}},
+								AllowedNamespaces: allowedNamespaces,
+							},
+						},
+						cmState,
+					).
+					Build(),
+				Scheme:   scheme.Scheme,
+				Recorder: recorder,
+			}
+		}
+		newCMState := func() *cachev1alpha1.CMState {
+			return &cachev1alpha1.CMState{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+				Spec: cachev1alpha1.CMStateSpec{
+					Audience:   []cachev1alpha1.CMAudience{{Kind: "Pod", Name: "app-"}},
+					CMTemplate: resourceName,
+				},
+			}
+		}
+		events := func() []string {
+			var recorded []string
+			for len(recorder.Events) > 0 {
+				recorded = append(recorded, <-recorder.Events)
+			}
+			return recorded
+		}
+
+		It("should record the creation of the configmap", func() {
+			controllerReconciler := newReconciler(newCMState())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events()).To(ConsistOf(
+				HavePrefix("Normal "+cachev1alpha1.EventReasonConfigMapCreated),
+				HavePrefix("Normal "+cachev1alpha1.EventReasonConfigMapCreated),
+			))
+		})
+
+		It("should audit renders under the hash of the admitted values", func() {
+			cmState := newCMState()
+			cmState.Spec.Values = map[string]string{"role": "reader"}
+			controllerReconciler := newReconciler(cmState)
+			out := &bytes.Buffer{}
+			controllerReconciler.Audit = audit.NewLogger(out)
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			record := audit.Record{}
+			Expect(json.NewDecoder(out).Decode(&record)).To(Succeed())
+			Expect(record.Kind).To(Equal(audit.KindRender))
+			Expect(record.ValueHash).To(Equal(audit.Hash(cmState.Spec.Values)))
+		})
+
+		It("should record failed renders", func() {
+			controllerReconciler := newReconciler(newCMState(), "team-a")
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).To(HaveOccurred())
+			Expect(events()).To(ConsistOf(
+				HavePrefix("Warning "+cachev1alpha1.EventReasonRenderFailed),
+				HavePrefix("Warning "+cachev1alpha1.EventReasonRenderFailed),
+			))
+		})
+
+		It("should only record the clean up once the configmap is deleted", func() {
+			cmState := newCMState()
+			cmState.Spec.Target = resourceName
+			cmState.Finalizers = []string{"test/keep"}
+			cmState.DeletionTimestamp = ptr.To(metav1.Now())
+			controllerReconciler := newReconciler(cmState)
+			Expect(controllerReconciler.Create(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"}})).To(Succeed())
+
+			deleteErr = fmt.Errorf("apiserver is unavailable")
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).To(MatchError(deleteErr))
+			Expect(events()).To(ConsistOf(HavePrefix("Normal " + cachev1alpha1.EventReasonPendingDeletion)))
+
+			deleteErr = nil
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events()).To(ContainElement(HavePrefix("Normal " + cachev1alpha1.EventReasonCleanedUp)))
+			Expect(errors.IsNotFound(controllerReconciler.Get(ctx, typeNamespacedName, &corev1.ConfigMap{}))).To(BeTrue())
+		})
+
+		It("should not record the clean up of an empty audience when the configmap is not deleted", func() {
+			cmState := newCMState()
+			cmState.Spec.Target = resourceName
+			cmState.Spec.Audience = nil
+			controllerReconciler := newReconciler(cmState)
+			Expect(controllerReconciler.Create(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"}})).To(Succeed())
+			metrics.ConfigMapBytes.WithLabelValues("default", resourceName, resourceName).Set(1)
+
+			deleteErr = fmt.Errorf("apiserver is unavailable")
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).To(MatchError(deleteErr))
+			Expect(events()).NotTo(ContainElement(HavePrefix("Normal " + cachev1alpha1.EventReasonCleanedUp)))
+			Expect(testutil.ToFloat64(metrics.ConfigMapBytes.WithLabelValues("default", resourceName, resourceName))).To(Equal(1.0))
+		})
+	})
+
+	Context("When the namespace may not use the template", func() {
+		const resourceName = "test-restricted"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			Expect(k8sClient.Create(ctx, &cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName},
+				Spec: cachev1alpha1.CMTemplateSpec{
+					Template: cachev1alpha1.Template{
+						CMTemplate:       map[string]string{"config": "static"},
+						TargetAnnotation: "test/target",
+					},
+					AllowedNamespaces: []string{"team-a"},
+				},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &cachev1alpha1.CMState{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+				Spec: cachev1alpha1.CMStateSpec{
+					Audience:   []cachev1alpha1.CMAudience{{Kind: "Pod", Name: "app-"}},
+					CMTemplate: resourceName,
+				},
+			})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.Delete(ctx, &cachev1alpha1.CMState{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+			})).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName},
+			})).To(Succeed())
+		})
+
+		It("should refuse to render the configmap", func() {
+			controllerReconciler := &CMStateReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).To(MatchError(ContainSubstring("namespace default is not allowed")))
+
+			err = k8sClient.Get(ctx, typeNamespacedName, &corev1.ConfigMap{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+	})
 })
@@ -18,27 +18,50 @@ package controller
 
 import (
 	"context"
+	"sync"
 
 	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	"github.com/stollenaar/cmstate-injector-operator/internal/health"
+	webhookcachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/internal/webhook/cache/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 var (
-	cmTemplates map[string]cachev1alpha1.CMTemplateSpec
+	cmTemplates = &templateRegistry{specs: make(map[string]cachev1alpha1.CMTemplateSpec)}
 )
 
+// templateRegistry holds the spec of every CMTemplate the controller has reconciled.
+type templateRegistry struct {
+	mu    sync.RWMutex
+	specs map[string]cachev1alpha1.CMTemplateSpec
+}
+
+func (t *templateRegistry) set(name string, spec cachev1alpha1.CMTemplateSpec) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.specs[name] = spec
+}
+
+func (t *templateRegistry) delete(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.specs, name)
+}
+
 // CMTemplateReconciler reconciles a CMTemplate object
 type CMTemplateReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
-}
-
-func init() {
-	cmTemplates = make(map[string]cachev1alpha1.CMTemplateSpec)
+	// Watchdog tracks the reconciles for the liveness check, it may be nil.
+	Watchdog *health.Watchdog
 }
 
 //+kubebuilder:rbac:groups=cache.spicedelver.me,resources=cmtemplates,verbs=get;list;watch;create;update;patch;delete
@@ -54,6 +77,7 @@ func init() {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.14.1/pkg/reconcile
 func (r *CMTemplateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	defer r.Watchdog.Track()()
 	log := log.FromContext(ctx)
 
 	cmTemplate := &cachev1alpha1.CMTemplate{}
@@ -62,23 +86,48 @@ func (r *CMTemplateReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		// If this is not nil we are already tracking one. So in this case we need to add to the audience
 		if apierrors.IsNotFound(err) {
 			log.Info("cmtemplate resource was not found. Ignoring, as the object must be deleted")
-			delete(cmTemplates, req.NamespacedName.Name)
+			cmTemplates.delete(req.NamespacedName.Name)
 			return ctrl.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
 		log.Error(err, "Failed to get cmtemplate")
 		return ctrl.Result{}, err
 	}
-	cmTemplates[req.NamespacedName.Name] = cmTemplate.Spec
+	cmTemplates.set(req.NamespacedName.Name, cmTemplate.Spec)
+
+	cmStates := &cachev1alpha1.CMStateList{}
+	if err := r.List(ctx, cmStates, client.MatchingFields{cmTemplateIndex: cmTemplate.Name}); err != nil {
+		log.Error(err, "Failed to list cmstates")
+		return ctrl.Result{}, err
+	}
+
+	original := cmTemplate.Status.DeepCopy()
+	setCMTemplateConditions(cmTemplate, cmStates.Items, webhookcachev1alpha1.ValidateSelector(cmTemplate.Spec.Selector))
+	if equality.Semantic.DeepEqual(original, &cmTemplate.Status) {
+		return ctrl.Result{}, nil
+	}
+	if err := r.Status().Update(ctx, cmTemplate); client.IgnoreNotFound(err) != nil {
+		log.Error(err, "Failed to update cmtemplate status")
+		return ctrl.Result{}, err
+	}
 	return ctrl.Result{}, nil
 }
 
+// cmTemplateForCMState maps a CMState to its CMTemplate.
+func cmTemplateForCMState(_ context.Context, obj client.Object) []reconcile.Request {
+	cmState, ok := obj.(*cachev1alpha1.CMState)
+	if !ok || cmState.Spec.CMTemplate == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: cmState.Spec.CMTemplate}}}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *CMTemplateReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		Named("CMTemplateController").
 		For(&cachev1alpha1.CMTemplate{}).
-		// Uncomment the following line adding a pointer to an instance of the controlled resource as an argument
-		// For().
+		// The conditions of a template summarize those of its cmstates
+		Watches(&cachev1alpha1.CMState{}, handler.EnqueueRequestsFromMapFunc(cmTemplateForCMState)).
 		Complete(r)
 }
@@ -22,7 +22,10 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -77,8 +80,66 @@ var _ = Describe("CMTemplate Controller", func() {
 				NamespacedName: typeNamespacedName,
 			})
 			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+
+			cmTemplate := &cachev1alpha1.CMTemplate{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cmTemplate)).To(Succeed())
+			Expect(cmTemplate.Status.ObservedGeneration).To(Equal(cmTemplate.Generation))
+			Expect(meta.IsStatusConditionTrue(cmTemplate.Status.Conditions, cachev1alpha1.ConditionReady)).To(BeTrue())
+			injected := meta.FindStatusCondition(cmTemplate.Status.Conditions, cachev1alpha1.ConditionInjected)
+			Expect(injected).NotTo(BeNil())
+			Expect(injected.Reason).To(Equal(cachev1alpha1.ReasonNoCMStates))
+
+			By("Breaking the selector of the template")
+			cmTemplate.Spec.Selector = &cachev1alpha1.TemplateSelector{MatchConditions: []cachev1alpha1.MatchCondition{
+				{Name: "broken", Expression: "object.metadata."},
+			}}
+			Expect(k8sClient.Update(ctx, cmTemplate)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cmTemplate)).To(Succeed())
+			degraded := meta.FindStatusCondition(cmTemplate.Status.Conditions, cachev1alpha1.ConditionDegraded)
+			Expect(degraded).NotTo(BeNil())
+			Expect(degraded.Status).To(Equal(metav1.ConditionTrue))
+			Expect(degraded.Reason).To(Equal(cachev1alpha1.ReasonInvalidSelector))
+			Expect(meta.IsStatusConditionFalse(cmTemplate.Status.Conditions, cachev1alpha1.ConditionReady)).To(BeTrue())
+		})
+	})
+
+	Context("When summarizing the cmstates of a template", func() {
+		ctx := context.Background()
+
+		It("should only count the cmstates of the template", func() {
+			cmState := func(namespace, template string) *cachev1alpha1.CMState {
+				return &cachev1alpha1.CMState{
+					ObjectMeta: metav1.ObjectMeta{Name: "cmstate-" + template, Namespace: namespace},
+					Spec:       cachev1alpha1.CMStateSpec{CMTemplate: template},
+				}
+			}
+			cmTemplate := &cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "vault"},
+				Status: cachev1alpha1.CMTemplateStatus{Conditions: []metav1.Condition{{
+					Type: cachev1alpha1.ConditionTerminating, Status: metav1.ConditionFalse, Reason: cachev1alpha1.ReasonAsExpected,
+				}}},
+			}
+			controllerReconciler := &CMTemplateReconciler{
+				Client: fake.NewClientBuilder().
+					WithScheme(scheme.Scheme).
+					WithStatusSubresource(&cachev1alpha1.CMTemplate{}).
+					WithIndex(&cachev1alpha1.CMState{}, cmTemplateIndex, indexCMTemplate).
+					WithObjects(cmTemplate, cmState("default", "vault"), cmState("apps", "vault"), cmState("default", "other")).
+					Build(),
+				Scheme: scheme.Scheme,
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: "vault"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(controllerReconciler.Get(ctx, types.NamespacedName{Name: "vault"}, cmTemplate)).To(Succeed())
+			injected := meta.FindStatusCondition(cmTemplate.Status.Conditions, cachev1alpha1.ConditionInjected)
+			Expect(injected).NotTo(BeNil())
+			Expect(injected.Message).To(Equal("2 cmstates"))
+			Expect(meta.FindStatusCondition(cmTemplate.Status.Conditions, cachev1alpha1.ConditionTerminating)).To(BeNil())
 		})
 	})
 })
@@ -0,0 +1,142 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+)
+
+// legacyConditionAvailable was the only condition of older versions, it is replaced by
+// cachev1alpha1.ConditionReady.
+const legacyConditionAvailable = "Available"
+
+// conditionSetter sets conditions for the given generation.
+type conditionSetter struct {
+	conditions *[]metav1.Condition
+	generation int64
+}
+
+func (s conditionSetter) set(conditionType string, status bool, reason, message string) {
+	condition := metav1.Condition{
+		Type:               conditionType,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: s.generation,
+		Reason:             reason,
+		Message:            message,
+	}
+	if status {
+		condition.Status = metav1.ConditionTrue
+	}
+	meta.SetStatusCondition(s.conditions, condition)
+}
+
+// setCMStateConditions sets the conditions of the CMState for the outcome of a reconcile.
+// renderErr is the failed render, terminating the reason the CMState is being removed.
+// A terminating CMState is not rendered anymore, so Rendered and Degraded keep their
+// last state.
+func setCMStateConditions(cmState *cachev1alpha1.CMState, renderErr error, terminating string) {
+	meta.RemoveStatusCondition(&cmState.Status.Conditions, legacyConditionAvailable)
+	cmState.Status.ObservedGeneration = cmState.Generation
+	conditions := conditionSetter{conditions: &cmState.Status.Conditions, generation: cmState.Generation}
+
+	switch {
+	case len(cmState.Spec.Audience) > 0:
+		conditions.set(cachev1alpha1.ConditionInjected, true, cachev1alpha1.ReasonAudienceJoined,
+			fmt.Sprintf("%d members in the audience", len(cmState.Spec.Audience)))
+	case cmState.Spec.Static:
+		conditions.set(cachev1alpha1.ConditionInjected, false, cachev1alpha1.ReasonStatic,
+			"The CMState is maintained by hand and has no audience")
+	default:
+		conditions.set(cachev1alpha1.ConditionInjected, false, cachev1alpha1.ReasonAudienceEmpty,
+			"The audience is empty")
+	}
+
+	if terminating != "" {
+		conditions.set(cachev1alpha1.ConditionTerminating, true, terminating,
+			fmt.Sprintf("Removing ConfigMap %s", cmState.Spec.Target))
+		conditions.set(cachev1alpha1.ConditionReady, false, terminating, "The CMState is being removed")
+		return
+	}
+	conditions.set(cachev1alpha1.ConditionTerminating, false, cachev1alpha1.ReasonAsExpected, "")
+
+	if renderErr != nil {
+		message := fmt.Sprintf("Failed to render ConfigMap %s: %s", cmState.Spec.Target, renderErr)
+		conditions.set(cachev1alpha1.ConditionRendered, false, cachev1alpha1.ReasonRenderFailed, message)
+		conditions.set(cachev1alpha1.ConditionDegraded, true, cachev1alpha1.ReasonRenderFailed, message)
+		conditions.set(cachev1alpha1.ConditionReady, false, cachev1alpha1.ReasonRenderFailed, message)
+		return
+	}
+	message := fmt.Sprintf("ConfigMap %s is rendered from cmtemplate %s", cmState.Spec.Target, cmState.Spec.CMTemplate)
+	conditions.set(cachev1alpha1.ConditionRendered, true, cachev1alpha1.ReasonRendered, message)
+	conditions.set(cachev1alpha1.ConditionDegraded, false, cachev1alpha1.ReasonAsExpected, "")
+	conditions.set(cachev1alpha1.ConditionReady, true, cachev1alpha1.ReasonRendered, message)
+}
+
+// setCMTemplateConditions sets the conditions of the CMTemplate from those of its CMStates.
+// selectorErr is why the selector of the CMTemplate cannot be matched.
+func setCMTemplateConditions(cmTemplate *cachev1alpha1.CMTemplate, cmStates []cachev1alpha1.CMState, selectorErr error) {
+	cmTemplate.Status.ObservedGeneration = cmTemplate.Generation
+	conditions := conditionSetter{conditions: &cmTemplate.Status.Conditions, generation: cmTemplate.Generation}
+
+	var failing []string
+	for _, cmState := range cmStates {
+		if meta.IsStatusConditionFalse(cmState.Status.Conditions, cachev1alpha1.ConditionRendered) {
+			failing = append(failing, cmState.Namespace+"/"+cmState.Name)
+		}
+	}
+
+	if len(cmStates) > 0 {
+		conditions.set(cachev1alpha1.ConditionInjected, true, cachev1alpha1.ReasonCMStatesExist,
+			fmt.Sprintf("%d cmstates", len(cmStates)))
+	} else {
+		conditions.set(cachev1alpha1.ConditionInjected, false, cachev1alpha1.ReasonNoCMStates,
+			"No pod has been injected with the cmtemplate")
+	}
+
+	if len(failing) > 0 {
+		message := fmt.Sprintf("%d of %d cmstates failed to render: %s", len(failing), len(cmStates), strings.Join(failing, ", "))
+		conditions.set(cachev1alpha1.ConditionRendered, false, cachev1alpha1.ReasonRenderFailed, message)
+		conditions.set(cachev1alpha1.ConditionDegraded, true, cachev1alpha1.ReasonRenderFailed, message)
+	} else {
+		conditions.set(cachev1alpha1.ConditionRendered, true, cachev1alpha1.ReasonRendered,
+			fmt.Sprintf("%d cmstates rendered", len(cmStates)))
+		conditions.set(cachev1alpha1.ConditionDegraded, false, cachev1alpha1.ReasonAsExpected, "")
+	}
+	if selectorErr != nil {
+		conditions.set(cachev1alpha1.ConditionDegraded, true, cachev1alpha1.ReasonInvalidSelector,
+			fmt.Sprintf("The selector is ignored: %s", selectorErr))
+	}
+
+	switch {
+	case selectorErr != nil:
+		conditions.set(cachev1alpha1.ConditionReady, false, cachev1alpha1.ReasonInvalidSelector,
+			meta.FindStatusCondition(cmTemplate.Status.Conditions, cachev1alpha1.ConditionDegraded).Message)
+	case len(failing) > 0:
+		conditions.set(cachev1alpha1.ConditionReady, false, cachev1alpha1.ReasonRenderFailed,
+			meta.FindStatusCondition(cmTemplate.Status.Conditions, cachev1alpha1.ConditionRendered).Message)
+	default:
+		conditions.set(cachev1alpha1.ConditionReady, true, cachev1alpha1.ReasonRendered, "The cmtemplate is registered and its cmstates are rendered")
+	}
+	// CMTemplates are deleted right away, earlier versions reported them not terminating
+	meta.RemoveStatusCondition(&cmTemplate.Status.Conditions, cachev1alpha1.ConditionTerminating)
+}
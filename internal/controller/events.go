@@ -0,0 +1,43 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+)
+
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// event records an event on the object, unless the reconciler has no recorder.
+func (r *CMStateReconciler) event(obj runtime.Object, eventType, reason, message string) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(obj, eventType, reason, message)
+}
+
+// templateRef references the CMTemplate of the CMState, which may no longer exist.
+func templateRef(cmState *cachev1alpha1.CMState) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: cachev1alpha1.GroupVersion.String(),
+		Kind:       "CMTemplate",
+		Name:       cmState.Spec.CMTemplate,
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package health holds the readiness and liveness checks of the operator.
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+// checkTimeout bounds the checks that wait on something.
+const checkTimeout = time.Second
+
+// ServingCertificate checks that the TLS server at address serves a certificate that
+// is currently valid.
+func ServingCertificate(address string) healthz.Checker {
+	return func(req *http.Request) error {
+		dialer := &tls.Dialer{
+			NetDialer: &net.Dialer{Timeout: checkTimeout},
+			// Only the validity period is checked, the server is this process
+			Config: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+		}
+		conn, err := dialer.DialContext(req.Context(), "tcp", address)
+		if err != nil {
+			return fmt.Errorf("webhook server is not serving: %w", err)
+		}
+		defer conn.Close() //nolint:errcheck
+
+		certificates := conn.(*tls.Conn).ConnectionState().PeerCertificates
+		if len(certificates) == 0 {
+			return errors.New("webhook server serves no certificate")
+		}
+		now := time.Now()
+		if leaf := certificates[0]; now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+			return fmt.Errorf("webhook certificate is only valid from %s to %s", leaf.NotBefore, leaf.NotAfter)
+		}
+		return nil
+	}
+}
+
+// CacheSynced checks that the informer caches have synced.
+func CacheSynced(informers cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), checkTimeout)
+		defer cancel()
+		if !informers.WaitForCacheSync(ctx) {
+			return errors.New("informer caches have not synced")
+		}
+		return nil
+	}
+}
+
+// InformersSynced checks that the informers of the objects have synced, starting the
+// ones that do not run yet. The webhook reads through them on every replica, while
+// the controllers only start theirs on the leader.
+func InformersSynced(informers cache.Cache, objs ...client.Object) healthz.Checker {
+	return func(req *http.Request) error {
+		for _, obj := range objs {
+			informer, err := informers.GetInformer(req.Context(), obj, cache.BlockUntilSynced(false))
+			if err != nil {
+				return fmt.Errorf("getting informer for %T: %w", obj, err)
+			}
+			if !informer.HasSynced() {
+				return fmt.Errorf("informer for %T has not synced", obj)
+			}
+		}
+		return nil
+	}
+}
+
+// Watchdog tracks the running reconciles, failing its liveness check when one of them
+// has been running for longer than Timeout. The workers of a controller are few, a
+// reconcile that hangs keeps the queue from draining.
+type Watchdog struct {
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	next    uint64
+	running map[uint64]time.Time
+	now     func() time.Time
+}
+
+// NewWatchdog returns a Watchdog for reconciles that must finish within timeout.
+func NewWatchdog(timeout time.Duration) *Watchdog {
+	return &Watchdog{Timeout: timeout, running: make(map[uint64]time.Time), now: time.Now}
+}
+
+// Track marks the start of a reconcile, the returned function marks its end. A nil
+// Watchdog tracks nothing.
+func (w *Watchdog) Track() (done func()) {
+	if w == nil {
+		return func() {}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	id := w.next
+	w.next++
+	w.running[id] = w.now()
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.running, id)
+	}
+}
+
+// Check is the liveness check of the Watchdog.
+func (w *Watchdog) Check(_ *http.Request) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	for _, started := range w.running {
+		if running := now.Sub(started); running > w.Timeout {
+			return fmt.Errorf("a reconcile has been running for %s, the queue is stuck", running.Round(time.Second))
+		}
+	}
+	return nil
+}
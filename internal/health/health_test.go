@@ -0,0 +1,105 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Health", func() {
+	var req *http.Request
+
+	BeforeEach(func() {
+		req = httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	})
+
+	Context("When checking the serving certificate", func() {
+		It("should pass while a valid certificate is served", func() {
+			server := httptest.NewTLSServer(http.NotFoundHandler())
+			DeferCleanup(server.Close)
+
+			Expect(ServingCertificate(server.Listener.Addr().String())(req)).To(Succeed())
+		})
+
+		It("should fail when nothing is serving", func() {
+			server := httptest.NewTLSServer(http.NotFoundHandler())
+			address := server.Listener.Addr().String()
+			server.Close()
+
+			Expect(ServingCertificate(address)(req)).To(MatchError(ContainSubstring("not serving")))
+		})
+	})
+
+	Context("When watching the reconciles", func() {
+		var (
+			watchdog *Watchdog
+			now      time.Time
+		)
+
+		BeforeEach(func() {
+			now = time.Now()
+			watchdog = NewWatchdog(time.Minute)
+			watchdog.now = func() time.Time { return now }
+		})
+
+		It("should fail while a reconcile runs for too long", func() {
+			done := watchdog.Track()
+			now = now.Add(30 * time.Second)
+			Expect(watchdog.Check(req)).To(Succeed())
+
+			now = now.Add(time.Minute)
+			Expect(watchdog.Check(req)).To(MatchError(ContainSubstring("queue is stuck")))
+
+			done()
+			Expect(watchdog.Check(req)).To(Succeed())
+		})
+
+		It("should track nothing without a watchdog", func() {
+			var watchdog *Watchdog
+			Expect(func() { watchdog.Track()() }).NotTo(Panic())
+		})
+	})
+
+	Context("When checking the informers", func() {
+		It("should pass once every informer has synced", func() {
+			informers := &informertest.FakeInformers{}
+			check := InformersSynced(informers, &corev1.Namespace{}, &corev1.ConfigMap{})
+
+			Expect(check(req)).To(MatchError(ContainSubstring("has not synced")))
+			for _, obj := range []client.Object{&corev1.Namespace{}, &corev1.ConfigMap{}} {
+				informer, err := informers.FakeInformerFor(req.Context(), obj)
+				Expect(err).NotTo(HaveOccurred())
+				informer.Synced = true
+			}
+			Expect(check(req)).To(Succeed())
+		})
+
+		It("should fail when an informer cannot be started", func() {
+			informers := &informertest.FakeInformers{Error: errors.New("no kind is registered")}
+			Expect(InformersSynced(informers, &corev1.Namespace{})(req)).To(MatchError(ContainSubstring("getting informer")))
+		})
+	})
+})
@@ -0,0 +1,98 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+)
+
+// collectTimeout bounds the listing of CMStates on a scrape.
+const collectTimeout = 10 * time.Second
+
+// audienceBuckets are the upper bounds of the audience size distribution.
+var audienceBuckets = []float64{0, 1, 2, 5, 10, 25, 50, 100}
+
+var (
+	cmStatesDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "cmstates"),
+		"Number of CMStates by namespace.", []string{"namespace"}, nil)
+	audienceSizeDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "audience_size"),
+		"Distribution of the number of audience members of the CMStates.", nil, nil)
+	pendingDeletionsDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "pending_deletions"),
+		"Number of CMStates being deleted or left without audience, by namespace.", []string{"namespace"}, nil)
+)
+
+// StateCollector reports the CMStates in the cluster. It lists them on every scrape,
+// so it should read from the cache.
+type StateCollector struct {
+	Reader client.Reader
+}
+
+// RegisterStateCollector registers a StateCollector reading through reader.
+func RegisterStateCollector(reader client.Reader) error {
+	return ctrlmetrics.Registry.Register(&StateCollector{Reader: reader})
+}
+
+// Describe implements prometheus.Collector.
+func (c *StateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cmStatesDesc
+	ch <- audienceSizeDesc
+	ch <- pendingDeletionsDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *StateCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	cmStates := &cachev1alpha1.CMStateList{}
+	if err := c.Reader.List(ctx, cmStates); err != nil {
+		ch <- prometheus.NewInvalidMetric(cmStatesDesc, err)
+		return
+	}
+
+	counts := make(map[string]int)
+	pending := make(map[string]int)
+	buckets := make(map[float64]uint64, len(audienceBuckets))
+	sum := 0
+	for _, cmState := range cmStates.Items {
+		counts[cmState.Namespace]++
+		if cmState.DeletionTimestamp != nil || (len(cmState.Spec.Audience) == 0 && !cmState.Spec.Static) {
+			pending[cmState.Namespace]++
+		}
+
+		size := len(cmState.Spec.Audience)
+		sum += size
+		for _, bound := range audienceBuckets {
+			if float64(size) <= bound {
+				buckets[bound]++
+			}
+		}
+	}
+
+	for namespace, count := range counts {
+		ch <- prometheus.MustNewConstMetric(cmStatesDesc, prometheus.GaugeValue, float64(count), namespace)
+		ch <- prometheus.MustNewConstMetric(pendingDeletionsDesc, prometheus.GaugeValue, float64(pending[namespace]), namespace)
+	}
+	ch <- prometheus.MustNewConstHistogram(audienceSizeDesc, uint64(len(cmStates.Items)), float64(sum), buckets)
+}
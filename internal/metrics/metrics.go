@@ -0,0 +1,112 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics holds the Prometheus metrics of the operator. They are registered
+// on the controller-runtime registry and served on its metrics endpoint.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "cmstate"
+
+// Outcomes of an admission decision for a template.
+const (
+	OutcomeInjected = "injected"
+	OutcomeDegraded = "degraded"
+	OutcomeSkipped  = "skipped"
+	OutcomeFailed   = "failed"
+	OutcomeDenied   = "denied"
+	OutcomeReleased = "released"
+	OutcomeErrored  = "errored"
+)
+
+// UnknownTemplate labels the decisions on templates that do not exist. Their names come
+// from pod annotations, labelling them would let pods grow the series without bound.
+const UnknownTemplate = "unknown"
+
+// Results of rendering a template.
+const (
+	RenderSuccess = "success"
+	RenderFailure = "failure"
+)
+
+var (
+	// AdmissionDecisions counts the decisions of the webhook per template. Requests that
+	// errored before reaching a template carry an empty template, templates that do not
+	// exist carry UnknownTemplate.
+	AdmissionDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "admission_decisions_total",
+		Help:      "Number of admission decisions of the webhook by operation, outcome and template.",
+	}, []string{"operation", "outcome", "template"})
+
+	// AdmissionDuration observes how long the webhook takes to handle a request.
+	AdmissionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "admission_duration_seconds",
+		Help:      "Time taken by the webhook to handle an admission request by operation.",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"operation"})
+
+	// Renders counts the renders of the ConfigMaps of CMStates per template.
+	Renders = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "renders_total",
+		Help:      "Number of ConfigMap renders by template and result.",
+	}, []string{"template", "result"})
+
+	// ConfigMapBytes is the size of the data of every rendered ConfigMap.
+	ConfigMapBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "configmap_bytes",
+		Help:      "Size in bytes of the data of the rendered ConfigMaps.",
+	}, []string{"namespace", "configmap", "template"})
+
+	// DriftCorrections counts the rendered ConfigMaps that were found changed and rewritten.
+	DriftCorrections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "drift_corrections_total",
+		Help:      "Number of rendered ConfigMaps rewritten because their data differed from the render, by template.",
+	}, []string{"template"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(AdmissionDecisions, AdmissionDuration, Renders, ConfigMapBytes, DriftCorrections)
+}
+
+// ObserveRender records the result of rendering the ConfigMap of a template, and its
+// size when it rendered.
+func ObserveRender(template string, data map[string]string, namespace, configMap string, err error) {
+	if err != nil {
+		Renders.WithLabelValues(template, RenderFailure).Inc()
+		return
+	}
+	Renders.WithLabelValues(template, RenderSuccess).Inc()
+
+	size := 0
+	for key, value := range data {
+		size += len(key) + len(value)
+	}
+	ConfigMapBytes.WithLabelValues(namespace, configMap, template).Set(float64(size))
+}
+
+// ForgetConfigMap drops the size of a ConfigMap that was cleaned up.
+func ForgetConfigMap(namespace, configMap string) {
+	ConfigMapBytes.DeletePartialMatch(prometheus.Labels{"namespace": namespace, "configmap": configMap})
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+)
+
+var _ = Describe("Metrics", func() {
+	Context("When collecting the CMStates", func() {
+		It("should report them per namespace with their audience sizes", func() {
+			scheme := runtime.NewScheme()
+			Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+			audience := []cachev1alpha1.CMAudience{{Kind: "Pod", Name: "a-"}, {Kind: "Deployment", Name: "b"}}
+			reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				&cachev1alpha1.CMState{ObjectMeta: metav1.ObjectMeta{Name: "one", Namespace: "team-a"},
+					Spec: cachev1alpha1.CMStateSpec{Audience: audience}},
+				&cachev1alpha1.CMState{ObjectMeta: metav1.ObjectMeta{Name: "two", Namespace: "team-a"}},
+				&cachev1alpha1.CMState{ObjectMeta: metav1.ObjectMeta{Name: "three", Namespace: "team-b"},
+					Spec: cachev1alpha1.CMStateSpec{Audience: audience[:1]}},
+			).Build()
+
+			expected := `
+# HELP cmstate_cmstates Number of CMStates by namespace.
+# TYPE cmstate_cmstates gauge
+cmstate_cmstates{namespace="team-a"} 2
+cmstate_cmstates{namespace="team-b"} 1
+# HELP cmstate_pending_deletions Number of CMStates being deleted or left without audience, by namespace.
+# TYPE cmstate_pending_deletions gauge
+cmstate_pending_deletions{namespace="team-a"} 1
+cmstate_pending_deletions{namespace="team-b"} 0
+# HELP cmstate_audience_size Distribution of the number of audience members of the CMStates.
+# TYPE cmstate_audience_size histogram
+cmstate_audience_size_bucket{le="0"} 1
+cmstate_audience_size_bucket{le="1"} 2
+cmstate_audience_size_bucket{le="2"} 3
+cmstate_audience_size_bucket{le="5"} 3
+cmstate_audience_size_bucket{le="10"} 3
+cmstate_audience_size_bucket{le="25"} 3
+cmstate_audience_size_bucket{le="50"} 3
+cmstate_audience_size_bucket{le="100"} 3
+cmstate_audience_size_bucket{le="+Inf"} 3
+cmstate_audience_size_sum 3
+cmstate_audience_size_count 3
+`
+			Expect(testutil.CollectAndCompare(&StateCollector{Reader: reader}, strings.NewReader(expected))).To(Succeed())
+		})
+	})
+
+	Context("When ConfigMaps are rendered", func() {
+		It("should track their size until they are forgotten", func() {
+			ObserveRender("sized", map[string]string{"key": "value"}, "default", "cmstate-sized", nil)
+			Expect(testutil.ToFloat64(ConfigMapBytes.WithLabelValues("default", "cmstate-sized", "sized"))).To(Equal(8.0))
+			Expect(testutil.ToFloat64(Renders.WithLabelValues("sized", RenderSuccess))).To(Equal(1.0))
+
+			ForgetConfigMap("default", "cmstate-sized")
+			Expect(testutil.CollectAndCount(ConfigMapBytes, "cmstate_configmap_bytes")).To(BeZero())
+		})
+	})
+})
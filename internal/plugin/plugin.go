@@ -0,0 +1,359 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plugin implements the commands of the kubectl-cmstate plugin. They read
+// CMStates, their ConfigMaps and the pods consuming them and print them the way
+// kubectl does.
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	"github.com/stollenaar/cmstate-injector-operator/internal/audit"
+	webhookcachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/internal/webhook/cache/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Options are shared by all commands.
+type Options struct {
+	Client client.Client
+	// Namespace is the namespace the commands look in, unless AllNamespaces is set.
+	Namespace     string
+	AllNamespaces bool
+	Out           io.Writer
+	// Now is used to print ages, it defaults to time.Now.
+	Now func() time.Time
+}
+
+func (o *Options) listOptions() []client.ListOption {
+	if o.AllNamespaces {
+		return nil
+	}
+	return []client.ListOption{client.InNamespace(o.Namespace)}
+}
+
+func (o *Options) age(t metav1.Time) string {
+	if t.IsZero() {
+		return "<unknown>"
+	}
+	now := time.Now
+	if o.Now != nil {
+		now = o.Now
+	}
+	return duration.HumanDuration(now().Sub(t.Time))
+}
+
+// List prints the CMStates with their template, target and audience.
+func (o *Options) List(ctx context.Context) error {
+	cmStates := &cachev1alpha1.CMStateList{}
+	if err := o.Client.List(ctx, cmStates, o.listOptions()...); err != nil {
+		return err
+	}
+	if len(cmStates.Items) == 0 {
+		if o.AllNamespaces {
+			fmt.Fprintln(o.Out, "No cmstates found")
+		} else {
+			fmt.Fprintf(o.Out, "No cmstates found in %s namespace.\n", o.Namespace)
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(o.Out, 0, 8, 3, ' ', 0)
+	header := "NAME\tTEMPLATE\tTARGET\tREADY\tSTATIC\tAUDIENCE\tAGE"
+	if o.AllNamespaces {
+		header = "NAMESPACE\t" + header
+	}
+	fmt.Fprintln(w, header)
+	for _, cmState := range cmStates.Items {
+		row := fmt.Sprintf("%s\t%s\t%s\t%s\t%t\t%s\t%s", cmState.Name, cmState.Spec.CMTemplate, target(&cmState),
+			ready(cmState.Status.Conditions), cmState.Spec.Static, audience(cmState.Spec.Audience), o.age(cmState.CreationTimestamp))
+		if o.AllNamespaces {
+			row = cmState.Namespace + "\t" + row
+		}
+		fmt.Fprintln(w, row)
+	}
+	return w.Flush()
+}
+
+// Describe prints a CMState with its values, audience, the live pods mounting its
+// ConfigMap, the rendered keys and its conditions.
+func (o *Options) Describe(ctx context.Context, name string) error {
+	cmState := &cachev1alpha1.CMState{}
+	if err := o.Client.Get(ctx, types.NamespacedName{Namespace: o.Namespace, Name: name}, cmState); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(o.Out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", cmState.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", cmState.Namespace)
+	fmt.Fprintf(w, "Template:\t%s\n", cmState.Spec.CMTemplate)
+	fmt.Fprintf(w, "Target:\t%s\n", target(cmState))
+	fmt.Fprintf(w, "Static:\t%t\n", cmState.Spec.Static)
+	if cmState.DeletionTimestamp != nil {
+		fmt.Fprintf(w, "Terminating:\tsince %s\n", o.age(*cmState.DeletionTimestamp))
+	}
+
+	// Values fed from secrets are not printed, the same way the audit log hides them.
+	values := cmState.Spec.Values
+	cmTemplate := &cachev1alpha1.CMTemplate{}
+	err := o.Client.Get(ctx, types.NamespacedName{Name: cmState.Spec.CMTemplate}, cmTemplate)
+	if err == nil {
+		values = audit.Redact(cmTemplate, values)
+	} else if !apierrors.IsNotFound(err) {
+		return err
+	}
+	fmt.Fprintln(w, "Values:")
+	if len(values) == 0 {
+		fmt.Fprintln(w, "  <none>")
+	}
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "  %s:\t%s\n", key, values[key])
+	}
+
+	fmt.Fprintln(w, "Audience:")
+	if len(cmState.Spec.Audience) == 0 {
+		fmt.Fprintln(w, "  <none>")
+	}
+	for _, member := range cmState.Spec.Audience {
+		fmt.Fprintf(w, "  %s\n", member.Kind+"/"+member.Name)
+	}
+
+	pods := &corev1.PodList{}
+	if err := o.Client.List(ctx, pods, client.InNamespace(cmState.Namespace)); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "Pods:")
+	live := 0
+	for _, pod := range pods.Items {
+		if Injected(&pod, cmState) {
+			live++
+			fmt.Fprintf(w, "  %s\t%s\t%s\n", pod.Name, pod.Status.Phase, o.age(pod.CreationTimestamp))
+		}
+	}
+	if live == 0 {
+		fmt.Fprintln(w, "  <none>")
+	}
+
+	fmt.Fprintln(w, "Rendered keys:")
+	cm := &corev1.ConfigMap{}
+	err = o.Client.Get(ctx, types.NamespacedName{Namespace: cmState.Namespace, Name: target(cmState)}, cm)
+	switch {
+	case apierrors.IsNotFound(err):
+		fmt.Fprintln(w, "  <configmap not rendered>")
+	case err != nil:
+		return err
+	case len(cm.Data) == 0:
+		fmt.Fprintln(w, "  <none>")
+	}
+	for _, key := range sortedKeys(cm.Data) {
+		fmt.Fprintf(w, "  %s\t%d bytes\n", key, len(cm.Data[key]))
+	}
+
+	fmt.Fprintln(w, "Conditions:")
+	if len(cmState.Status.Conditions) == 0 {
+		fmt.Fprintln(w, "  <none>")
+	} else {
+		fmt.Fprintln(w, "  TYPE\tSTATUS\tREASON\tAGE\tMESSAGE")
+	}
+	for _, condition := range cmState.Status.Conditions {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", condition.Type, condition.Status, condition.Reason,
+			o.age(condition.LastTransitionTime), condition.Message)
+	}
+	return w.Flush()
+}
+
+// Pod prints the CMStates injected into the pod, and the templates it names that no
+// CMState provides.
+func (o *Options) Pod(ctx context.Context, name string) error {
+	pod := &corev1.Pod{}
+	if err := o.Client.Get(ctx, types.NamespacedName{Namespace: o.Namespace, Name: name}, pod); err != nil {
+		return err
+	}
+	cmStates := &cachev1alpha1.CMStateList{}
+	if err := o.Client.List(ctx, cmStates, client.InNamespace(pod.Namespace)); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(o.Out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "CMSTATE\tTEMPLATE\tTARGET\tINJECTED")
+	injected := webhookcachev1alpha1.InjectedTemplates(pod)
+	provided := []string{}
+	for _, cmState := range cmStates.Items {
+		if !Injected(pod, &cmState) {
+			continue
+		}
+		provided = append(provided, cmState.Spec.CMTemplate)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", cmState.Name, cmState.Spec.CMTemplate, target(&cmState),
+			slices.Contains(injected, cmState.Spec.CMTemplate))
+	}
+	for _, templateName := range requestedTemplates(pod) {
+		if !slices.Contains(provided, templateName) {
+			fmt.Fprintf(w, "<none>\t%s\t%s\t%t\n", templateName, webhookcachev1alpha1.CMStateName(templateName), false)
+		}
+	}
+	return w.Flush()
+}
+
+// Uninjected prints the pods that name templates in their annotations but were not
+// injected with all of them.
+func (o *Options) Uninjected(ctx context.Context) error {
+	pods := &corev1.PodList{}
+	if err := o.Client.List(ctx, pods, o.listOptions()...); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(o.Out, 0, 8, 3, ' ', 0)
+	header := "POD\tMISSING\tAGE"
+	if o.AllNamespaces {
+		header = "NAMESPACE\t" + header
+	}
+	fmt.Fprintln(w, header)
+	for _, pod := range pods.Items {
+		missing := Missing(&pod)
+		if len(missing) == 0 {
+			continue
+		}
+		row := fmt.Sprintf("%s\t%s\t%s", pod.Name, strings.Join(missing, ","), o.age(pod.CreationTimestamp))
+		if o.AllNamespaces {
+			row = pod.Namespace + "\t" + row
+		}
+		fmt.Fprintln(w, row)
+	}
+	return w.Flush()
+}
+
+// Missing returns the templates the pod names in its annotations that it was not
+// injected with.
+func Missing(pod *corev1.Pod) []string {
+	var missing []string
+	for _, templateName := range requestedTemplates(pod) {
+		cmState := &cachev1alpha1.CMState{
+			ObjectMeta: metav1.ObjectMeta{Name: webhookcachev1alpha1.CMStateName(templateName)},
+			Spec:       cachev1alpha1.CMStateSpec{CMTemplate: templateName},
+		}
+		if !Injected(pod, cmState) {
+			missing = append(missing, templateName)
+		}
+	}
+	return missing
+}
+
+// Injected reports whether the webhook injected the CMState into the pod. The pod is
+// marked with the templates it was injected with, pods injected by older versions are
+// recognized by the ConfigMap they consume or the target annotation naming the CMState.
+// Templates without a mount path or envFrom only set their target annotation.
+func Injected(pod *corev1.Pod, cmState *cachev1alpha1.CMState) bool {
+	if slices.Contains(webhookcachev1alpha1.InjectedTemplates(pod), cmState.Spec.CMTemplate) ||
+		Consumes(pod, target(cmState)) {
+		return true
+	}
+	for _, value := range pod.GetAnnotations() {
+		if value == cmState.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// Consumes reports whether the pod mounts the ConfigMap or loads it into the
+// environment of one of its containers.
+func Consumes(pod *corev1.Pod, configMap string) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.ConfigMap != nil && volume.ConfigMap.Name == configMap {
+			return true
+		}
+		if volume.Projected == nil {
+			continue
+		}
+		for _, source := range volume.Projected.Sources {
+			if source.ConfigMap != nil && source.ConfigMap.Name == configMap {
+				return true
+			}
+		}
+	}
+	containers := slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers)
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil && envFrom.ConfigMapRef.Name == configMap {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestedTemplates returns the templates named in the annotations of a pod that did
+// not opt out of injection.
+func requestedTemplates(pod *corev1.Pod) []string {
+	names := webhookcachev1alpha1.TemplateNames(pod.GetAnnotations())
+	if slices.Contains(names, webhookcachev1alpha1.TemplateOptOut) ||
+		pod.GetAnnotations()[webhookcachev1alpha1.InjectAnnotation] == "false" {
+		return nil
+	}
+	return names
+}
+
+// target returns the ConfigMap of the CMState, which is named after it until the
+// controller has recorded it.
+func target(cmState *cachev1alpha1.CMState) string {
+	if cmState.Spec.Target != "" {
+		return cmState.Spec.Target
+	}
+	return cmState.Name
+}
+
+// ready returns the status of the Ready condition, if it has been set.
+func ready(conditions []metav1.Condition) string {
+	condition := meta.FindStatusCondition(conditions, cachev1alpha1.ConditionReady)
+	if condition == nil {
+		return "Unknown"
+	}
+	return string(condition.Status)
+}
+
+func audience(members []cachev1alpha1.CMAudience) string {
+	if len(members) == 0 {
+		return "<none>"
+	}
+	names := make([]string, 0, len(members))
+	for _, member := range members {
+		names = append(names, member.Kind+"/"+member.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	webhookcachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/internal/webhook/cache/v1alpha1"
+)
+
+var _ = Describe("Plugin", func() {
+	var out *bytes.Buffer
+
+	options := func(objs ...client.Object) *Options {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+		out = &bytes.Buffer{}
+		return &Options{
+			Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+			Namespace: "default",
+			Out:       out,
+		}
+	}
+
+	cmTemplate := func() *cachev1alpha1.CMTemplate {
+		return &cachev1alpha1.CMTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault"},
+			Spec: cachev1alpha1.CMTemplateSpec{Template: cachev1alpha1.Template{
+				ValueSources: []cachev1alpha1.ValueSource{
+					{Name: "token", SecretKeyRef: &corev1.SecretKeySelector{Key: "token"}},
+				},
+			}},
+		}
+	}
+
+	cmState := func() *cachev1alpha1.CMState {
+		return &cachev1alpha1.CMState{
+			ObjectMeta: metav1.ObjectMeta{Name: webhookcachev1alpha1.CMStateName("vault"), Namespace: "default"},
+			Spec: cachev1alpha1.CMStateSpec{
+				CMTemplate: "vault",
+				Target:     webhookcachev1alpha1.CMStateName("vault"),
+				Audience:   []cachev1alpha1.CMAudience{{Kind: "Deployment", Name: "app"}},
+				Values:     map[string]string{"token": "s3cr3t", "role": "reader"},
+			},
+			Status: cachev1alpha1.CMStateStatus{Conditions: []metav1.Condition{
+				{Type: cachev1alpha1.ConditionReady, Status: metav1.ConditionTrue, Reason: cachev1alpha1.ReasonRendered, Message: "rendered"},
+			}},
+		}
+	}
+
+	pod := func(name string, templates string, configMaps ...string) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Annotations: map[string]string{webhookcachev1alpha1.TemplateAnnotation: templates},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+		for _, configMap := range configMaps {
+			pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+				Name: configMap,
+				VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: configMap},
+				}},
+			})
+		}
+		return pod
+	}
+
+	It("should list cmstates with their target and audience", func() {
+		o := options(cmState())
+		Expect(o.List(context.Background())).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("TARGET"))
+		Expect(out.String()).To(ContainSubstring(webhookcachev1alpha1.CMStateName("vault")))
+		Expect(out.String()).To(ContainSubstring("Deployment/app"))
+		Expect(out.String()).To(MatchRegexp(`READY.*\n.*\sTrue\s`))
+	})
+
+	It("should say when there are no cmstates", func() {
+		o := options()
+		Expect(o.List(context.Background())).To(Succeed())
+		Expect(out.String()).To(Equal("No cmstates found in default namespace.\n"))
+	})
+
+	It("should describe a cmstate with its pods, keys and conditions", func() {
+		o := options(cmTemplate(), cmState(),
+			&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: webhookcachev1alpha1.CMStateName("vault"), Namespace: "default"},
+				Data:       map[string]string{"config.hcl": "12345"},
+			},
+			pod("app-1", "vault", webhookcachev1alpha1.CMStateName("vault")),
+			pod("other", ""),
+		)
+		Expect(o.Describe(context.Background(), webhookcachev1alpha1.CMStateName("vault"))).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("app-1"))
+		Expect(out.String()).NotTo(ContainSubstring("other"))
+		Expect(out.String()).To(MatchRegexp(`config\.hcl\s+5 bytes`))
+		Expect(out.String()).To(MatchRegexp(`Ready\s+True\s+Rendered`))
+		Expect(out.String()).To(ContainSubstring("reader"))
+		Expect(out.String()).NotTo(ContainSubstring("s3cr3t"))
+	})
+
+	It("should show the cmstates a pod consumes", func() {
+		o := options(cmState(), pod("app-1", "vault,missing", webhookcachev1alpha1.CMStateName("vault")))
+		Expect(o.Pod(context.Background(), "app-1")).To(Succeed())
+		Expect(out.String()).To(MatchRegexp(webhookcachev1alpha1.CMStateName("vault") + `\s+vault`))
+		Expect(out.String()).To(MatchRegexp(`<none>\s+missing`))
+	})
+
+	It("should find pods that were never injected", func() {
+		optedOut := pod("opted-out", "vault")
+		optedOut.Annotations[webhookcachev1alpha1.InjectAnnotation] = "false"
+		o := options(
+			pod("injected", "vault", webhookcachev1alpha1.CMStateName("vault")),
+			pod("skipped", "vault"),
+			optedOut,
+		)
+		Expect(o.Uninjected(context.Background())).To(Succeed())
+		Expect(out.String()).To(MatchRegexp(`skipped\s+vault`))
+		Expect(out.String()).NotTo(ContainSubstring("injected "))
+		Expect(out.String()).NotTo(ContainSubstring("opted-out"))
+	})
+
+	It("should see configmaps loaded into the environment", func() {
+		envPod := pod("env", "vault")
+		envPod.Spec.Containers[0].EnvFrom = []corev1.EnvFromSource{{
+			ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "target"}},
+		}}
+		Expect(Consumes(envPod, "target")).To(BeTrue())
+		Expect(Consumes(envPod, "other")).To(BeFalse())
+	})
+
+	It("should see templates that only set their target annotation", func() {
+		marked := pod("marked", "vault")
+		marked.Annotations[webhookcachev1alpha1.InjectedAnnotation] = "vault"
+		annotated := pod("annotated", "vault")
+		annotated.Annotations["cache.spicedelver.me/target"] = webhookcachev1alpha1.CMStateName("vault")
+		Expect(Missing(marked)).To(BeEmpty())
+		Expect(Missing(annotated)).To(BeEmpty())
+
+		o := options(cmState(), marked, annotated)
+		Expect(o.Describe(context.Background(), webhookcachev1alpha1.CMStateName("vault"))).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("marked"))
+		Expect(out.String()).To(ContainSubstring("annotated"))
+
+		out.Reset()
+		Expect(o.Pod(context.Background(), "marked")).To(Succeed())
+		Expect(out.String()).To(MatchRegexp(webhookcachev1alpha1.CMStateName("vault") + `\s+vault\s+\S+\s+true`))
+	})
+})
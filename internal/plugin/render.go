@@ -0,0 +1,164 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	"github.com/stollenaar/cmstate-injector-operator/internal/preview"
+)
+
+// RenderOptions configure an offline render.
+type RenderOptions struct {
+	Scheme *runtime.Scheme
+	// Manifests hold the CMTemplates and the pods to render, as YAML or JSON. Any other
+	// object, e.g. the Secrets and ConfigMaps read by value sources or a Namespace with
+	// its labels, is made available to the templates.
+	Manifests []io.Reader
+	// Namespace is used for pods without a namespace and for rendering values.
+	Namespace string
+	// Values render static cmstates of the templates when the manifests hold no pods.
+	Values map[string]string
+	// DefaultFailurePolicy and DeniedNamespaces configure the webhook, see CMStateCreator.
+	DefaultFailurePolicy cachev1alpha1.FailurePolicy
+	DeniedNamespaces     []string
+	Out                  io.Writer
+}
+
+// Render prints the ConfigMaps and the pod patches the operator would produce from
+// the manifests, without a cluster. It fails when a pod would be denied.
+func (o *RenderOptions) Render(ctx context.Context) error {
+	objects, err := o.decode()
+	if err != nil {
+		return err
+	}
+
+	var templateNames []string
+	var pods []*corev1.Pod
+	namespaces := map[string]bool{o.Namespace: false}
+	for _, obj := range objects {
+		switch obj := obj.(type) {
+		case *cachev1alpha1.CMTemplate:
+			templateNames = append(templateNames, obj.Name)
+		case *corev1.Pod:
+			pods = append(pods, obj)
+		case *corev1.Namespace:
+			namespaces[obj.Name] = true
+		}
+		if _, ok := namespaces[obj.GetNamespace()]; !ok && obj.GetNamespace() != "" {
+			namespaces[obj.GetNamespace()] = false
+		}
+	}
+	if len(templateNames) == 0 {
+		return errors.New("the manifests hold no cmtemplate")
+	}
+	for _, pod := range pods {
+		if pod.Namespace == "" {
+			pod.Namespace = o.Namespace
+		}
+	}
+	for name, found := range namespaces {
+		if !found {
+			objects = append(objects, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})
+		}
+	}
+
+	previewer := &preview.Previewer{
+		Client:               fake.NewClientBuilder().WithScheme(o.Scheme).WithObjects(objects...).Build(),
+		Scheme:               o.Scheme,
+		DefaultFailurePolicy: o.DefaultFailurePolicy,
+		DeniedNamespaces:     o.DeniedNamespaces,
+	}
+	var results []*preview.Result
+	if len(pods) == 0 {
+		result, err := previewer.Values(ctx, o.Namespace, templateNames, o.Values)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+	}
+	for _, pod := range pods {
+		result, err := previewer.Pod(ctx, pod)
+		if err != nil {
+			return fmt.Errorf("previewing pod %s: %w", pod.Name+pod.GenerateName, err)
+		}
+		results = append(results, result)
+	}
+
+	var denied []error
+	for i, result := range results {
+		out, err := yaml.Marshal(result)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(o.Out, "---")
+		}
+		if _, err := o.Out.Write(out); err != nil {
+			return err
+		}
+		if !result.Allowed {
+			denied = append(denied, fmt.Errorf("pod %s would be denied: %s", result.Pod, result.Message))
+		}
+	}
+	return errors.Join(denied...)
+}
+
+// decode reads the objects from the manifests, which may hold several YAML documents.
+func (o *RenderOptions) decode() ([]client.Object, error) {
+	decoder := serializer.NewCodecFactory(o.Scheme).UniversalDeserializer()
+	var objects []client.Object
+	for _, manifest := range o.Manifests {
+		reader := utilyaml.NewYAMLReader(bufio.NewReader(manifest))
+		for {
+			document, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if len(bytes.TrimSpace(document)) == 0 {
+				continue
+			}
+			obj, _, err := decoder.Decode(document, nil, nil)
+			if err != nil {
+				return nil, fmt.Errorf("decoding manifest: %w", err)
+			}
+			object, ok := obj.(client.Object)
+			if !ok {
+				return nil, fmt.Errorf("decoding manifest: unexpected %T", obj)
+			}
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
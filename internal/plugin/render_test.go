@@ -0,0 +1,149 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	"github.com/stollenaar/cmstate-injector-operator/internal/preview"
+	webhookcachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/internal/webhook/cache/v1alpha1"
+)
+
+const cmTemplateManifest = `
+apiVersion: cache.spicedelver.me/v1alpha1
+kind: CMTemplate
+metadata:
+  name: vault
+spec:
+  template:
+    annotationreplace:
+      role: ${role}
+    cmtemplate:
+      config.hcl: |
+        role = "${role}"
+        token = "${token}"
+    targetAnnotation: cache.spicedelver.me/target
+    mountPath: /etc/vault
+    valueSources:
+      - name: token
+        placeholder: ${token}
+        secretKeyRef:
+          name: vault
+          key: token
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: vault
+  namespace: apps
+data:
+  token: czNjcjN0
+`
+
+const podManifest = `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: app
+  namespace: apps
+  annotations:
+    cache.spicedelver.me/cmtemplate: vault
+    role: reader
+spec:
+  containers:
+    - name: app
+      image: nginx
+`
+
+var _ = Describe("Render", func() {
+	var out *bytes.Buffer
+
+	render := func(values map[string]string, manifests ...string) error {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+		out = &bytes.Buffer{}
+		options := &RenderOptions{
+			Scheme:               scheme,
+			Namespace:            "apps",
+			Values:               values,
+			DefaultFailurePolicy: cachev1alpha1.FailurePolicyDeny,
+			DeniedNamespaces:     webhookcachev1alpha1.DefaultDeniedNamespaces,
+			Out:                  out,
+		}
+		for _, manifest := range manifests {
+			options.Manifests = append(options.Manifests, io.Reader(strings.NewReader(manifest)))
+		}
+		return options.Render(context.Background())
+	}
+
+	It("should render the configmap and patch of a pod", func() {
+		Expect(render(nil, cmTemplateManifest, podManifest)).To(Succeed())
+
+		result := &preview.Result{}
+		Expect(yaml.Unmarshal(out.Bytes(), result)).To(Succeed())
+		Expect(result.Allowed).To(BeTrue())
+		Expect(result.Pod).To(Equal("app"))
+		Expect(result.CMStates).To(HaveLen(1))
+		Expect(result.CMStates[0].Created).To(BeTrue())
+		Expect(result.ConfigMaps).To(HaveLen(1))
+		Expect(result.ConfigMaps[0].Data).To(HaveKeyWithValue("config.hcl", ContainSubstring(`role = "reader"`)))
+		Expect(result.ConfigMaps[0].Data).To(HaveKeyWithValue("config.hcl", ContainSubstring(`token = "s3cr3t"`)))
+		Expect(result.Patch).To(ContainElement(HaveField("Path", "/spec/volumes")))
+	})
+
+	It("should render the templates from values without pods", func() {
+		Expect(render(map[string]string{"role": "writer"}, cmTemplateManifest)).To(Succeed())
+
+		result := &preview.Result{}
+		Expect(yaml.Unmarshal(out.Bytes(), result)).To(Succeed())
+		Expect(result.ConfigMaps).To(HaveLen(1))
+		Expect(result.ConfigMaps[0].Namespace).To(Equal("apps"))
+		Expect(result.ConfigMaps[0].Data).To(HaveKeyWithValue("config.hcl", ContainSubstring(`role = "writer"`)))
+		Expect(result.Patch).To(BeEmpty())
+	})
+
+	It("should fail on pods that would be denied", func() {
+		pod := strings.ReplaceAll(podManifest, "cmtemplate: vault", "cmtemplate: missing")
+		Expect(render(nil, cmTemplateManifest, pod)).To(MatchError(ContainSubstring("pod app would be denied")))
+	})
+
+	It("should leave pods in denied namespaces alone", func() {
+		pod := strings.ReplaceAll(podManifest, "namespace: apps", "namespace: kube-system")
+		Expect(render(nil, cmTemplateManifest, pod)).To(Succeed())
+
+		result := &preview.Result{}
+		Expect(yaml.Unmarshal(out.Bytes(), result)).To(Succeed())
+		Expect(result.Allowed).To(BeTrue())
+		Expect(result.Patch).To(BeEmpty())
+		Expect(result.ConfigMaps).To(BeEmpty())
+	})
+
+	It("should need a cmtemplate", func() {
+		Expect(render(nil, podManifest)).To(MatchError(ContainSubstring("no cmtemplate")))
+	})
+})
@@ -0,0 +1,170 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package preview
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/apis/apiserver"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/authenticatorfactory"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/authorization/authorizerfactory"
+	authenticationv1 "k8s.io/client-go/kubernetes/typed/authentication/v1"
+	authorizationv1 "k8s.io/client-go/kubernetes/typed/authorization/v1"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Path is where the webhook server serves previews.
+const Path = "/preview"
+
+// maxRequestBytes bounds the size of a preview request, pods are far smaller.
+const maxRequestBytes = 3 << 20
+
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// Request asks what creating the pod in the namespace would do. The namespace of
+// the pod is used when Namespace is empty.
+type Request struct {
+	Namespace string     `json:"namespace,omitempty"`
+	Pod       corev1.Pod `json:"pod"`
+}
+
+// Handler serves previews of pods to callers that may create pods in their namespace,
+// the preview shows them the ConfigMaps such a pod would mount anyway. Callers
+// authenticate with a bearer token, which is reviewed by the API server.
+type Handler struct {
+	Previewer     *Previewer
+	Authenticator authenticator.Request
+	Authorizer    authorizer.Authorizer
+	Log           logr.Logger
+}
+
+// NewHandler returns a handler delegating authentication and authorization to the
+// API server of the config.
+func NewHandler(config *rest.Config, httpClient *http.Client, previewer *Previewer) (*Handler, error) {
+	authenticationClient, err := authenticationv1.NewForConfigAndClient(config, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	authorizationClient, err := authorizationv1.NewForConfigAndClient(config, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	backoff := &wait.Backoff{Duration: 500 * time.Millisecond, Factor: 1.5, Jitter: 0.2, Steps: 5}
+
+	authenticatorConfig := authenticatorfactory.DelegatingAuthenticatorConfig{
+		Anonymous:                &apiserver.AnonymousAuthConfig{Enabled: false},
+		CacheTTL:                 time.Minute,
+		TokenAccessReviewClient:  authenticationClient,
+		TokenAccessReviewTimeout: 10 * time.Second,
+		WebhookRetryBackoff:      backoff,
+	}
+	delegatingAuthenticator, _, err := authenticatorConfig.New()
+	if err != nil {
+		return nil, fmt.Errorf("creating authenticator: %w", err)
+	}
+	authorizerConfig := authorizerfactory.DelegatingAuthorizerConfig{
+		SubjectAccessReviewClient: authorizationClient,
+		AllowCacheTTL:             5 * time.Minute,
+		DenyCacheTTL:              30 * time.Second,
+		WebhookRetryBackoff:       backoff,
+	}
+	delegatingAuthorizer, err := authorizerConfig.New()
+	if err != nil {
+		return nil, fmt.Errorf("creating authorizer: %w", err)
+	}
+
+	return &Handler{
+		Previewer:     previewer,
+		Authenticator: delegatingAuthenticator,
+		Authorizer:    delegatingAuthorizer,
+		Log:           ctrl.Log.WithName("preview"),
+	}, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "previews are requested with POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	authenticated, ok, err := h.Authenticator.AuthenticateRequest(r)
+	if err != nil {
+		h.Log.Error(err, "Authentication failed")
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	req := &Request{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(req); err != nil {
+		http.Error(w, fmt.Sprintf("decoding preview request: %v", err), http.StatusBadRequest)
+		return
+	}
+	pod := &req.Pod
+	if req.Namespace != "" {
+		pod.Namespace = req.Namespace
+	}
+	if pod.Namespace == "" {
+		http.Error(w, "the preview request has no namespace", http.StatusBadRequest)
+		return
+	}
+
+	user := authenticated.User
+	decision, _, err := h.Authorizer.Authorize(r.Context(), authorizer.AttributesRecord{
+		User:            user,
+		Verb:            "create",
+		Namespace:       pod.Namespace,
+		APIVersion:      "v1",
+		Resource:        "pods",
+		Name:            pod.Name,
+		ResourceRequest: true,
+	})
+	if err != nil {
+		h.Log.Error(err, "Authorization failed", "user", user.GetName())
+		http.Error(w, fmt.Sprintf("Authorization for user %s failed", user.GetName()), http.StatusInternalServerError)
+		return
+	}
+	if decision != authorizer.DecisionAllow {
+		http.Error(w, fmt.Sprintf("user %s may not create pods in namespace %s", user.GetName(), pod.Namespace),
+			http.StatusForbidden)
+		return
+	}
+
+	result, err := h.Previewer.Pod(r.Context(), pod)
+	if err != nil {
+		h.Log.Error(err, "Preview failed", "namespace", pod.Namespace)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		h.Log.Error(err, "Writing preview failed")
+	}
+}
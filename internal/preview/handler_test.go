@@ -0,0 +1,148 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package preview
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	webhookcachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/internal/webhook/cache/v1alpha1"
+)
+
+var _ = Describe("Handler", func() {
+	var (
+		k8sClient  client.Client
+		handler    *Handler
+		authorized []authorizer.Attributes
+	)
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(cachev1alpha1.AddToScheme(scheme)).To(Succeed())
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}},
+			&cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "vault"},
+				Spec: cachev1alpha1.CMTemplateSpec{Template: cachev1alpha1.Template{
+					CMTemplate:        map[string]string{"config": "role = ${role}"},
+					AnnotationReplace: map[string]string{"role": "${role}"},
+					TargetAnnotation:  "test/target",
+					MountPath:         "/etc/vault",
+				}},
+			},
+		).Build()
+
+		authorized = nil
+		handler = &Handler{
+			Previewer: &Previewer{Client: k8sClient, Scheme: scheme, DefaultFailurePolicy: cachev1alpha1.FailurePolicyDeny},
+			Authenticator: authenticator.RequestFunc(func(r *http.Request) (*authenticator.Response, bool, error) {
+				if r.Header.Get("Authorization") != "Bearer token" {
+					return nil, false, nil
+				}
+				return &authenticator.Response{User: &user.DefaultInfo{Name: "developer"}}, true, nil
+			}),
+			Authorizer: authorizer.AuthorizerFunc(func(_ context.Context, a authorizer.Attributes) (authorizer.Decision, string, error) {
+				authorized = append(authorized, a)
+				if a.GetNamespace() == "apps" {
+					return authorizer.DecisionAllow, "", nil
+				}
+				return authorizer.DecisionDeny, "", nil
+			}),
+			Log: logr.Discard(),
+		}
+	})
+
+	serve := func(namespace string, token string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(Request{Namespace: namespace, Pod: corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "app-",
+				Annotations: map[string]string{
+					webhookcachev1alpha1.TemplateAnnotation: "vault",
+					"role":                                  "reader",
+				},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+		req := httptest.NewRequest(http.MethodPost, Path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	It("should preview a pod without persisting anything", func() {
+		rec := serve("apps", "token")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+
+		result := &Result{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), result)).To(Succeed())
+		Expect(result.Allowed).To(BeTrue())
+		Expect(result.Pod).To(Equal("app-"))
+		Expect(result.Patch).NotTo(BeEmpty())
+		Expect(result.CMStates).To(HaveLen(1))
+		Expect(result.CMStates[0].Created).To(BeTrue())
+		Expect(result.ConfigMaps).To(HaveLen(1))
+		Expect(result.ConfigMaps[0].Data).To(HaveKeyWithValue("config", "role = reader"))
+
+		Expect(authorized).To(HaveLen(1))
+		Expect(authorized[0].GetVerb()).To(Equal("create"))
+		Expect(authorized[0].GetResource()).To(Equal("pods"))
+		Expect(authorized[0].GetUser().GetName()).To(Equal("developer"))
+
+		cmStates := &cachev1alpha1.CMStateList{}
+		Expect(k8sClient.List(context.Background(), cmStates)).To(Succeed())
+		Expect(cmStates.Items).To(BeEmpty())
+	})
+
+	It("should reject unauthenticated callers", func() {
+		Expect(serve("apps", "wrong").Code).To(Equal(http.StatusUnauthorized))
+		Expect(authorized).To(BeEmpty())
+	})
+
+	It("should reject callers that may not create pods in the namespace", func() {
+		Expect(serve("kube-system", "token").Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should need a namespace", func() {
+		Expect(serve("", "token").Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should only serve POST", func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+		Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
@@ -0,0 +1,128 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package preview shows what the operator would do with a pod or a set of values: the
+// admission patch of the webhook and the ConfigMaps rendered by the controller. It
+// runs their code against any client and persists nothing.
+package preview
+
+import (
+	"context"
+	"fmt"
+
+	"gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	"github.com/stollenaar/cmstate-injector-operator/internal/controller"
+	webhookcachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/internal/webhook/cache/v1alpha1"
+)
+
+// Result is the outcome of a preview.
+type Result struct {
+	// Pod is the name, or generateName, of the previewed pod. It is empty for values.
+	Pod      string   `json:"pod,omitempty"`
+	Allowed  bool     `json:"allowed"`
+	Message  string   `json:"message,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	// Patch is the JSON patch the webhook would apply to the pod.
+	Patch []jsonpatch.JsonPatchOperation `json:"patch,omitempty"`
+	// CMStates are the cmstates the pod would join or create.
+	CMStates []webhookcachev1alpha1.PreviewCMState `json:"cmstates,omitempty"`
+	// ConfigMaps are the ConfigMaps rendered for the cmstates.
+	ConfigMaps []*corev1.ConfigMap `json:"configmaps,omitempty"`
+}
+
+// Previewer runs the webhook and the controller against Client.
+type Previewer struct {
+	Client client.Client
+	Scheme *runtime.Scheme
+	// DefaultFailurePolicy and DeniedNamespaces configure the webhook, see CMStateCreator.
+	DefaultFailurePolicy cachev1alpha1.FailurePolicy
+	DeniedNamespaces     []string
+}
+
+// Pod previews the creation of the pod in its namespace.
+func (p *Previewer) Pod(ctx context.Context, pod *corev1.Pod) (*Result, error) {
+	preview, err := webhookcachev1alpha1.PreviewPodCreate(ctx, p.Client, p.DefaultFailurePolicy, p.DeniedNamespaces, pod)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Pod:      pod.Name,
+		Allowed:  preview.Response.Allowed,
+		Warnings: preview.Response.Warnings,
+		Patch:    preview.Response.Patches,
+		CMStates: preview.CMStates,
+	}
+	if result.Pod == "" {
+		result.Pod = pod.GenerateName
+	}
+	if preview.Response.Result != nil {
+		result.Message = preview.Response.Result.Message
+	}
+	for _, cmState := range preview.CMStates {
+		cm, err := p.render(ctx, cmState.CMState)
+		if err != nil {
+			return nil, err
+		}
+		result.ConfigMaps = append(result.ConfigMaps, cm)
+	}
+	return result, nil
+}
+
+// Values previews static cmstates of the templates in the namespace, rendered from
+// the values.
+func (p *Previewer) Values(ctx context.Context, namespace string, templateNames []string, values map[string]string) (*Result, error) {
+	result := &Result{Allowed: true}
+	for _, templateName := range templateNames {
+		cmState := &cachev1alpha1.CMState{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: cachev1alpha1.GroupVersion.String(),
+				Kind:       "CMState",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      webhookcachev1alpha1.CMStateName(templateName),
+				Namespace: namespace,
+			},
+			Spec: cachev1alpha1.CMStateSpec{
+				CMTemplate: templateName,
+				Static:     true,
+				Values:     values,
+			},
+		}
+		cm, err := p.render(ctx, cmState)
+		if err != nil {
+			return nil, err
+		}
+		result.CMStates = append(result.CMStates, webhookcachev1alpha1.PreviewCMState{CMState: cmState, Created: true})
+		result.ConfigMaps = append(result.ConfigMaps, cm)
+	}
+	return result, nil
+}
+
+func (p *Previewer) render(ctx context.Context, cmState *cachev1alpha1.CMState) (*corev1.ConfigMap, error) {
+	reconciler := &controller.CMStateReconciler{Client: p.Client, Scheme: p.Scheme}
+	cm, err := reconciler.RenderConfigMap(ctx, cmState)
+	if err != nil {
+		return nil, fmt.Errorf("rendering cmstate %s: %w", cmState.Name, err)
+	}
+	return cm, nil
+}
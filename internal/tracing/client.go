@@ -0,0 +1,177 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Reader wraps a client.Reader, tracing every call it makes to the API server.
+type Reader struct {
+	client.Reader
+}
+
+// Get implements client.Reader.
+func (r *Reader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) (err error) {
+	ctx, span := startCall(ctx, "get", obj, key)
+	defer func() { End(span, err) }()
+	return r.Reader.Get(ctx, key, obj, opts...)
+}
+
+// List implements client.Reader.
+func (r *Reader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) (err error) {
+	ctx, span := startCall(ctx, "list", list, client.ObjectKey{})
+	defer func() { End(span, err) }()
+	return r.Reader.List(ctx, list, opts...)
+}
+
+// Client wraps a client.Client, tracing every call it makes to the API server.
+type Client struct {
+	client.Client
+}
+
+// Get implements client.Client.
+func (c *Client) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) (err error) {
+	ctx, span := startCall(ctx, "get", obj, key)
+	defer func() { End(span, err) }()
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+// List implements client.Client.
+func (c *Client) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) (err error) {
+	ctx, span := startCall(ctx, "list", list, client.ObjectKey{})
+	defer func() { End(span, err) }()
+	return c.Client.List(ctx, list, opts...)
+}
+
+// Create implements client.Client.
+func (c *Client) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) (err error) {
+	ctx, span := startCall(ctx, "create", obj, client.ObjectKeyFromObject(obj))
+	defer func() { End(span, err) }()
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+// Update implements client.Client.
+func (c *Client) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) (err error) {
+	ctx, span := startCall(ctx, "update", obj, client.ObjectKeyFromObject(obj))
+	defer func() { End(span, err) }()
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+// Patch implements client.Client.
+func (c *Client) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) (err error) {
+	ctx, span := startCall(ctx, "patch", obj, client.ObjectKeyFromObject(obj))
+	defer func() { End(span, err) }()
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+// Delete implements client.Client.
+func (c *Client) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) (err error) {
+	ctx, span := startCall(ctx, "delete", obj, client.ObjectKeyFromObject(obj))
+	defer func() { End(span, err) }()
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+// Status implements client.Client.
+func (c *Client) Status() client.SubResourceWriter {
+	return &subResourceWriter{SubResourceWriter: c.Client.Status(), subResource: "status"}
+}
+
+// SubResource implements client.Client.
+func (c *Client) SubResource(subResource string) client.SubResourceClient {
+	sub := c.Client.SubResource(subResource)
+	return &subResourceClient{
+		SubResourceClient: sub,
+		writer:            subResourceWriter{SubResourceWriter: sub, subResource: subResource},
+	}
+}
+
+// subResourceWriter traces the writes to a subresource like those to the object itself.
+type subResourceWriter struct {
+	client.SubResourceWriter
+	subResource string
+}
+
+// Create implements client.SubResourceWriter.
+func (w *subResourceWriter) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) (err error) {
+	ctx, span := w.startCall(ctx, "create", obj)
+	defer func() { End(span, err) }()
+	return w.SubResourceWriter.Create(ctx, obj, subResource, opts...)
+}
+
+// Update implements client.SubResourceWriter.
+func (w *subResourceWriter) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) (err error) {
+	ctx, span := w.startCall(ctx, "update", obj)
+	defer func() { End(span, err) }()
+	return w.SubResourceWriter.Update(ctx, obj, opts...)
+}
+
+// Patch implements client.SubResourceWriter.
+func (w *subResourceWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) (err error) {
+	ctx, span := w.startCall(ctx, "patch", obj)
+	defer func() { End(span, err) }()
+	return w.SubResourceWriter.Patch(ctx, obj, patch, opts...)
+}
+
+func (w *subResourceWriter) startCall(ctx context.Context, verb string, obj client.Object) (context.Context, trace.Span) {
+	ctx, span := startCall(ctx, verb, obj, client.ObjectKeyFromObject(obj))
+	span.SetAttributes(attribute.String("k8s.subresource", w.subResource))
+	return ctx, span
+}
+
+// subResourceClient traces the reads and writes of a subresource.
+type subResourceClient struct {
+	client.SubResourceClient
+	writer subResourceWriter
+}
+
+// Get implements client.SubResourceReader.
+func (c *subResourceClient) Get(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceGetOption) (err error) {
+	ctx, span := c.writer.startCall(ctx, "get", obj)
+	defer func() { End(span, err) }()
+	return c.SubResourceClient.Get(ctx, obj, subResource, opts...)
+}
+
+// Create implements client.SubResourceWriter.
+func (c *subResourceClient) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	return c.writer.Create(ctx, obj, subResource, opts...)
+}
+
+// Update implements client.SubResourceWriter.
+func (c *subResourceClient) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	return c.writer.Update(ctx, obj, opts...)
+}
+
+// Patch implements client.SubResourceWriter.
+func (c *subResourceClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	return c.writer.Patch(ctx, obj, patch, opts...)
+}
+
+// startCall starts the span of an API call on the object.
+func startCall(ctx context.Context, verb string, obj any, key client.ObjectKey) (context.Context, trace.Span) {
+	kind := fmt.Sprintf("%T", obj)
+	attrs := []attribute.KeyValue{attribute.String("k8s.verb", verb), attribute.String("k8s.type", kind)}
+	if key.Name != "" {
+		attrs = append(attrs, attribute.String("k8s.namespace", key.Namespace), attribute.String("k8s.name", key.Name))
+	}
+	return Tracer().Start(ctx, "k8s."+verb, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
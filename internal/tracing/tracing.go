@@ -0,0 +1,130 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing sets up OpenTelemetry tracing for the webhook and the controllers.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Exporters of the spans.
+const (
+	// ExporterNone disables tracing.
+	ExporterNone = "none"
+	// ExporterOTLP sends the spans to an OTLP gRPC endpoint. The endpoint, TLS and
+	// headers are taken from the standard OTEL_EXPORTER_OTLP_* environment variables
+	// unless an endpoint is given.
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes the spans to stdout, for local debugging.
+	ExporterStdout = "stdout"
+)
+
+const (
+	serviceName = "cmstate-injector-operator"
+	tracerName  = "github.com/stollenaar/cmstate-injector-operator"
+
+	// TraceParentAnnotation carries the W3C traceparent of the admission that created a
+	// CMState, so its reconciles can link back to it.
+	TraceParentAnnotation = "cache.spicedelver.me/traceparent"
+)
+
+var propagator = propagation.TraceContext{}
+
+// Setup installs the global tracer provider for the exporter. The returned function
+// flushes and stops it.
+func Setup(ctx context.Context, exporter, endpoint string) (shutdown func(context.Context) error, err error) {
+	var spanExporter sdktrace.SpanExporter
+	switch exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		var opts []otlptracegrpc.Option
+		if endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(endpoint))
+		}
+		spanExporter, err = otlptracegrpc.New(ctx, opts...)
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating %s exporter: %w", exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("creating tracing resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(spanExporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of the operator.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// End records the error on the span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectParent stores the span context of ctx on the object, see TraceParentAnnotation.
+func InjectParent(ctx context.Context, obj client.Object) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[TraceParentAnnotation] = carrier.Get("traceparent")
+	obj.SetAnnotations(annotations)
+}
+
+// ParentLink returns the link to the span stored on the object, and whether it has one.
+func ParentLink(obj client.Object) (trace.Link, bool) {
+	traceParent, ok := obj.GetAnnotations()[TraceParentAnnotation]
+	if !ok {
+		return trace.Link{}, false
+	}
+	ctx := propagator.Extract(context.Background(), propagation.MapCarrier{"traceparent": traceParent})
+	spanContext := trace.SpanContextFromContext(ctx)
+	return trace.Link{SpanContext: spanContext}, spanContext.IsValid()
+}
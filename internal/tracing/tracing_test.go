@@ -0,0 +1,101 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Tracing", func() {
+	var recorder *tracetest.SpanRecorder
+
+	BeforeEach(func() {
+		recorder = tracetest.NewSpanRecorder()
+		previous := otel.GetTracerProvider()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		DeferCleanup(func() { otel.SetTracerProvider(previous) })
+	})
+
+	It("should link to the span stored on an object", func() {
+		ctx, span := Tracer().Start(context.Background(), "admission")
+		obj := &corev1.ConfigMap{}
+		InjectParent(ctx, obj)
+		span.End()
+
+		link, ok := ParentLink(obj)
+		Expect(ok).To(BeTrue())
+		Expect(link.SpanContext.TraceID()).To(Equal(span.SpanContext().TraceID()))
+		Expect(link.SpanContext.SpanID()).To(Equal(span.SpanContext().SpanID()))
+	})
+
+	It("should not store anything without a span", func() {
+		obj := &corev1.ConfigMap{}
+		InjectParent(context.Background(), obj)
+		Expect(obj.GetAnnotations()).To(BeEmpty())
+
+		_, ok := ParentLink(obj)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should trace the calls of a client as children of the caller", func() {
+		c := &Client{Client: fake.NewClientBuilder().WithObjects(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"},
+		}).Build()}
+
+		ctx, parent := Tracer().Start(context.Background(), "parent")
+		Expect(c.Get(ctx, types.NamespacedName{Name: "cm", Namespace: "default"}, &corev1.ConfigMap{})).To(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: "missing", Namespace: "default"}, &corev1.ConfigMap{})).NotTo(Succeed())
+		parent.End()
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(3))
+		for _, span := range spans[:2] {
+			Expect(span.Name()).To(Equal("k8s.get"))
+			Expect(span.Parent().SpanID()).To(Equal(parent.SpanContext().SpanID()))
+		}
+		Expect(spans[1].Events()).NotTo(BeEmpty(), "the error is recorded")
+	})
+
+	It("should trace the writes to subresources", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}}
+		c := &Client{Client: fake.NewClientBuilder().WithObjects(pod).WithStatusSubresource(pod).Build()}
+
+		pod.Status.Phase = corev1.PodRunning
+		Expect(c.Status().Update(context.Background(), pod)).To(Succeed())
+		Expect(c.SubResource("status").Patch(context.Background(), pod, client.Merge)).To(Succeed())
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(2))
+		Expect(spans[0].Name()).To(Equal("k8s.update"))
+		Expect(spans[1].Name()).To(Equal("k8s.patch"))
+		for _, span := range spans {
+			Expect(span.Attributes()).To(ContainElement(attribute.String("k8s.subresource", "status")))
+		}
+	})
+})
@@ -0,0 +1,240 @@
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	"github.com/stollenaar/cmstate-injector-operator/internal/tracing"
+	"github.com/stollenaar/cmstate-injector-operator/internal/workload"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// audienceBackoff bounds the retries of an audience update that conflicted with
+// another webhook replica. A replica admitting a burst of pods patches the cmstate
+// back to back, the retries keep up with it for a few seconds, well within the
+// timeout of the webhook.
+var audienceBackoff = wait.Backoff{
+	Steps:    20,
+	Duration: 10 * time.Millisecond,
+	Factor:   1.2,
+	Jitter:   1.0,
+}
+
+// audienceLocks serializes the audience updates of a cmstate within one webhook. A
+// workload scaling up sends all its pods through the webhook at once, letting them
+// race each other would only leave the replicas racing. A lock is dropped once no
+// admission holds or waits for it. A nil audienceLocks serializes nothing.
+type audienceLocks struct {
+	mu    sync.Mutex
+	locks map[types.NamespacedName]*audienceLock
+}
+
+type audienceLock struct {
+	sync.Mutex
+	// users counts the admissions holding or waiting for the lock.
+	users int
+}
+
+func newAudienceLocks() *audienceLocks {
+	return &audienceLocks{locks: make(map[types.NamespacedName]*audienceLock)}
+}
+
+// lock locks the audience of the cmstate, the returned function unlocks it.
+func (l *audienceLocks) lock(key types.NamespacedName) (unlock func()) {
+	if l == nil {
+		return func() {}
+	}
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &audienceLock{}
+		l.locks[key] = lock
+	}
+	lock.users++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if lock.users--; lock.users == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
+
+// audienceUpdate returns the updated audience and whether it changed.
+type audienceUpdate func(audience []cachev1alpha1.CMAudience) ([]cachev1alpha1.CMAudience, bool)
+
+// joinAudience adds the member to the audience.
+func joinAudience(member cachev1alpha1.CMAudience) audienceUpdate {
+	return func(audience []cachev1alpha1.CMAudience) ([]cachev1alpha1.CMAudience, bool) {
+		if findIndex(audience, member) != -1 {
+			return audience, false
+		}
+		return append(audience, member), true
+	}
+}
+
+// leaveAudience removes a deleted pod from the audience. Workloads are released by the
+// reconciler once they are gone, older versions tracked the pods of a workload by
+// generateName though, so that entry is handed over to the workload instead.
+func leaveAudience(member, legacyMember cachev1alpha1.CMAudience) audienceUpdate {
+	return func(audience []cachev1alpha1.CMAudience) ([]cachev1alpha1.CMAudience, bool) {
+		if !workload.IsWorkload(member) {
+			index := findIndex(audience, member)
+			if index == -1 {
+				return audience, false
+			}
+			return slices.Delete(audience, index, index+1), true
+		}
+
+		index := findIndex(audience, legacyMember)
+		if legacyMember.Name == "" || index == -1 {
+			return audience, false
+		}
+		audience = slices.Delete(audience, index, index+1)
+		if findIndex(audience, member) == -1 {
+			audience = append(audience, member)
+		}
+		return audience, true
+	}
+}
+
+// dropMember removes the member from the audience, undoing joinAudience.
+func dropMember(member cachev1alpha1.CMAudience) audienceUpdate {
+	return func(audience []cachev1alpha1.CMAudience) ([]cachev1alpha1.CMAudience, bool) {
+		index := findIndex(audience, member)
+		if index == -1 {
+			return audience, false
+		}
+		return slices.Delete(audience, index, index+1), true
+	}
+}
+
+// createCMState creates the cmstate, or joins the audience of the one another
+// admission call, possibly on another replica, created first. It reports whether
+// the audience changed.
+func (hook *cmStateCreator) createCMState(ctx context.Context, cmState *cachev1alpha1.CMState, member cachev1alpha1.CMAudience) (bool, error) {
+	unlock := hook.audienceLocks.lock(client.ObjectKeyFromObject(cmState))
+	defer unlock()
+
+	return hook.createCMStateLocked(ctx, cmState, joinAudience(member))
+}
+
+// createCMStateLocked creates the cmstate, whose audience already holds the update,
+// and applies the update to the existing one otherwise.
+func (hook *cmStateCreator) createCMStateLocked(ctx context.Context, cmState *cachev1alpha1.CMState, update audienceUpdate) (bool, error) {
+	// Lets the reconcile rendering the cmstate link back to this admission
+	tracing.InjectParent(ctx, cmState)
+	err := hook.Client.Create(ctx, cmState)
+	if err == nil {
+		hook.recordCMStateEvent(cmState, cachev1alpha1.EventReasonCMStateCreated,
+			fmt.Sprintf("Created for cmtemplate %s with audience %s", cmState.Spec.CMTemplate, describeAudience(cmState.Spec.Audience)))
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return err == nil, err
+	}
+
+	// The cache may not have seen the cmstate yet
+	if err := hook.reader().Get(ctx, client.ObjectKeyFromObject(cmState), cmState); err != nil {
+		return false, err
+	}
+	return hook.patchAudienceLocked(ctx, cmState, update)
+}
+
+// patchAudience applies the update to the audience of the cmstate. The patch is guarded
+// by the resource version, so concurrent updates are not lost, and is retried on the
+// latest cmstate on conflict. It reports whether the audience changed.
+func (hook *cmStateCreator) patchAudience(ctx context.Context, cmState *cachev1alpha1.CMState, update audienceUpdate) (bool, error) {
+	unlock := hook.audienceLocks.lock(client.ObjectKeyFromObject(cmState))
+	defer unlock()
+
+	return hook.patchAudienceLocked(ctx, cmState, update)
+}
+
+func (hook *cmStateCreator) patchAudienceLocked(ctx context.Context, cmState *cachev1alpha1.CMState, update audienceUpdate) (bool, error) {
+	changed := false
+	err := retry.RetryOnConflict(audienceBackoff, func() error {
+		original := cmState.DeepCopy()
+		cmState.Spec.Audience, changed = update(slices.Clone(cmState.Spec.Audience))
+		if !changed {
+			return nil
+		}
+
+		err := hook.Client.Patch(ctx, cmState, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}))
+		if apierrors.IsConflict(err) {
+			if err := hook.reader().Get(ctx, client.ObjectKeyFromObject(cmState), cmState); err != nil {
+				return err
+			}
+		}
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		// The reconciler removes cmstates whose audience emptied, e.g. when their
+		// workload scaled to zero. Pods joining afterwards bring it back.
+		return hook.recreateCMState(ctx, cmState, update)
+	}
+	if changed && err == nil {
+		hook.recordCMStateEvent(cmState, cachev1alpha1.EventReasonAudienceChanged,
+			fmt.Sprintf("Audience has %d members", len(cmState.Spec.Audience)))
+	}
+	return changed, err
+}
+
+// recreateCMState creates the removed cmstate again when the update adds to its
+// audience. Its ConfigMap is rendered anew.
+func (hook *cmStateCreator) recreateCMState(ctx context.Context, cmState *cachev1alpha1.CMState, update audienceUpdate) (bool, error) {
+	audience, joined := update(nil)
+	if !joined {
+		// Nothing left to leave
+		return false, nil
+	}
+	recreated := &cachev1alpha1.CMState{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        cmState.Name,
+			Namespace:   cmState.Namespace,
+			Labels:      cmState.Labels,
+			Annotations: cmState.Annotations,
+		},
+		Spec: *cmState.Spec.DeepCopy(),
+	}
+	recreated.Spec.Target = ""
+	recreated.Spec.Audience = audience
+
+	changed, err := hook.createCMStateLocked(ctx, recreated, update)
+	*cmState = *recreated
+	return changed, err
+}
+
+// describeAudience lists the members of the audience for events.
+func describeAudience(audience []cachev1alpha1.CMAudience) string {
+	members := make([]string, 0, len(audience))
+	for _, member := range audience {
+		members = append(members, member.Kind+" "+member.Name)
+	}
+	return strings.Join(members, ", ")
+}
+
+func (hook *cmStateCreator) resolver() *workload.Resolver {
+	return &workload.Resolver{Client: hook.Client, APIReader: hook.APIReader}
+}
+
+// reader returns the reader for the latest cmstates, bypassing the cache when possible.
+func (hook *cmStateCreator) reader() client.Reader {
+	if hook.APIReader != nil {
+		return hook.APIReader
+	}
+	return hook.Client
+}
@@ -5,25 +5,48 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	"github.com/stollenaar/cmstate-injector-operator/internal/audit"
+	"github.com/stollenaar/cmstate-injector-operator/internal/metrics"
+	"github.com/stollenaar/cmstate-injector-operator/internal/tracing"
+	"github.com/stollenaar/cmstate-injector-operator/internal/workload"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	v1admission "k8s.io/api/admission/v1"
-	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
-// +kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=ignore,sideEffects=None,groups="",resources=pods,verbs=create;delete,versions=v1,name=cmstate-operator-webhook.spicedelver.me,admissionReviewVersions=v1
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
 
+// +kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=ignore,sideEffects=NoneOnDryRun,groups="",resources=pods,verbs=create;update;delete,versions=v1,name=cmstate-operator-webhook.spicedelver.me,admissionReviewVersions=v1,reinvocationPolicy=IfNeeded
+
+const (
+	// TemplateAnnotation names the CMTemplates a pod consumes, comma separated. More
+	// templates can be added through indexed annotations, e.g. TemplateAnnotation + ".1".
+	TemplateAnnotation = "cache.spicedelver.me/cmtemplate"
+	// TemplateOptOut as the TemplateAnnotation value keeps selector based templates off the pod.
+	TemplateOptOut = "none"
+	// InjectedAnnotation marks the templates injected into a pod, comma separated. When another
+	// webhook has the pod sent through again, their cmstates are left alone and only the
+	// containers that were added since get the ConfigMaps.
+	InjectedAnnotation = "cache.spicedelver.me/injected"
+)
+
+// PatchOperation is a JSON patch operation, see RFC 6902.
 type PatchOperation struct {
 	Op    string      `json:"op"`
 	Path  string      `json:"path"`
@@ -32,18 +55,73 @@ type PatchOperation struct {
 
 type cmStateCreator struct {
 	Client client.Client
+	// DefaultFailurePolicy applies to templates without a failure policy and to templates that do not exist.
+	DefaultFailurePolicy cachev1alpha1.FailurePolicy
+	Recorder             record.EventRecorder
+	// APIReader reads cmstates bypassing the cache, e.g. after losing a race to create one.
+	APIReader client.Reader
+	// DeniedNamespaces are never injected, whatever their pods ask for.
+	DeniedNamespaces []string
+	// Audit receives every decision, it may be nil.
+	Audit *audit.Logger
+
+	// preview collects the cmstates of a previewed admission, see PreviewPodCreate.
+	preview *Preview
+	// audienceLocks serializes the audience updates of this webhook, it may be nil.
+	audienceLocks *audienceLocks
 }
 
-func CMStateCreator(mgr ctrl.Manager) error {
+func CMStateCreator(mgr ctrl.Manager, defaultFailurePolicy cachev1alpha1.FailurePolicy, deniedNamespaces []string, auditLog *audit.Logger) error {
+	switch defaultFailurePolicy {
+	case cachev1alpha1.FailurePolicyDeny, cachev1alpha1.FailurePolicyAllowWithWarning, cachev1alpha1.FailurePolicyAllowSilently:
+	default:
+		return fmt.Errorf("unknown failure policy %q", defaultFailurePolicy)
+	}
+
 	hookServer := mgr.GetWebhookServer()
-	hookServer.Register("/mutate-v1-pod", &webhook.Admission{Handler: &cmStateCreator{Client: mgr.GetClient()}})
+	hookServer.Register("/mutate-v1-pod", &webhook.Admission{Handler: &cmStateCreator{
+		Client:               &tracing.Client{Client: mgr.GetClient()},
+		DefaultFailurePolicy: defaultFailurePolicy,
+		Recorder:             mgr.GetEventRecorderFor("cmstate-webhook"),
+		APIReader:            &tracing.Reader{Reader: mgr.GetAPIReader()},
+		DeniedNamespaces:     deniedNamespaces,
+		Audit:                auditLog,
+		audienceLocks:        newAudienceLocks(),
+	}})
 	return nil
 }
 
 // cmStateCreator creates the cmstate if needed or patches the audience.
 func (hook *cmStateCreator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if dryRun(req) {
+		// Events are side effects as well
+		dryRunHook := *hook
+		dryRunHook.Recorder = nil
+		hook = &dryRunHook
+	}
+
+	defer func(start time.Time) {
+		metrics.AdmissionDuration.WithLabelValues(string(req.Operation)).Observe(time.Since(start).Seconds())
+	}(time.Now())
+
+	ctx, span := tracing.Tracer().Start(ctx, "CMStateCreator.Handle", trace.WithAttributes(
+		attribute.String("admission.operation", string(req.Operation)),
+		attribute.String("admission.uid", string(req.UID)),
+		attribute.String("k8s.namespace", req.Namespace),
+		attribute.String("k8s.name", req.Name),
+		attribute.Bool("admission.dry_run", dryRun(req)),
+	))
 	resp, err := hook.handleInner(ctx, req)
+	if err == nil {
+		span.SetAttributes(attribute.Bool("admission.allowed", resp.Allowed))
+	}
+	tracing.End(span, err)
 	if err != nil {
+		hook.recordDecision(req, nil, "", nil, nil, metrics.OutcomeErrored, err.Error())
+		pod := &corev1.Pod{}
+		if req.Operation == v1admission.Create && json.Unmarshal(req.Object.Raw, pod) == nil {
+			hook.recordEvent(pod, ReasonInjectionFailed, err.Error())
+		}
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
 	return *resp
@@ -57,6 +135,14 @@ func (hook *cmStateCreator) handleInner(ctx context.Context, req admission.Reque
 	switch req.Operation {
 	case v1admission.Create:
 		err = json.Unmarshal(req.Object.Raw, pod)
+	case v1admission.Update:
+		oldPod := &corev1.Pod{}
+		if err = json.Unmarshal(req.OldObject.Raw, oldPod); err == nil {
+			err = json.Unmarshal(req.Object.Raw, pod)
+		}
+		if err == nil {
+			return hook.handlePodUpdate(req, oldPod, pod, ctx)
+		}
 	case v1admission.Delete:
 		err = json.Unmarshal(req.OldObject.Raw, pod)
 	default:
@@ -67,12 +153,52 @@ func (hook *cmStateCreator) handleInner(ctx context.Context, req admission.Reque
 		log.Error(err, "Error decoding request into Pod")
 		return nil, errors.Wrap(err, "error decoding request into Pod")
 	}
+	patch := newPodPatch(pod)
 
-	cmState := &cachev1alpha1.CMState{}
-	cmTemplate := &cachev1alpha1.CMTemplate{}
-	if pod.Annotations["cache.spicedelver.me/cmtemplate"] != "" {
+	templateNames := TemplateNames(pod.GetAnnotations())
+	if slices.Contains(templateNames, TemplateOptOut) {
+		resp := admission.Allowed("skipping cmstate check due to opt-out annotation")
+		return &resp, nil
+	}
+
+	namespace := &corev1.Namespace{}
+	if req.Operation == v1admission.Create {
+		err = hook.Client.Get(ctx, types.NamespacedName{Name: req.Namespace}, namespace)
+		if err != nil {
+			log.Error(err, "fetching namespace has resulted in an error")
+			return hook.failPod(req, pod, nil, fmt.Sprintf("fetching namespace %s: %v", req.Namespace, err)), nil
+		}
+		// Pods that were injected before opting out still leave their audiences on delete
+		if reason := hook.optOutReason(pod, namespace); reason != "" {
+			resp := admission.Allowed(reason)
+			return &resp, nil
+		}
+	}
 
-		crdName := generateName(pod.Annotations["cache.spicedelver.me/cmtemplate"])
+	if len(templateNames) == 0 && req.Operation == v1admission.Create {
+		templateNames, err = hook.selectTemplates(ctx, namespace, pod)
+		if err != nil {
+			log.Error(err, "selecting cmtemplates has resulted in an error")
+			return hook.failPod(req, pod, nil, fmt.Sprintf("selecting cmtemplates: %v", err)), nil
+		}
+		if len(templateNames) > 0 {
+			// Record the selected templates so the pod is handled like an annotated one from here on
+			patch.setAnnotation(TemplateAnnotation, strings.Join(templateNames, ","))
+		}
+	}
+	if len(templateNames) == 0 {
+		resp := admission.Allowed("skipping cmstate check due to missing annotation")
+		return &resp, nil
+	}
+
+	var warnings []string
+	cmStates := make([]*cachev1alpha1.CMState, 0, len(templateNames))
+	cmTemplates := make([]*cachev1alpha1.CMTemplate, 0, len(templateNames))
+	for _, templateName := range templateNames {
+		cmState := &cachev1alpha1.CMState{}
+		cmTemplate := &cachev1alpha1.CMTemplate{}
+
+		crdName := CMStateName(templateName)
 		err = hook.Client.Get(
 			ctx,
 			types.NamespacedName{
@@ -82,220 +208,405 @@ func (hook *cmStateCreator) handleInner(ctx context.Context, req admission.Reque
 			cmState,
 		)
 
-		if err != nil && !apierrors.IsNotFound(err) {
+		if err != nil && !apierrors.IsNotFound(err) && req.Operation == v1admission.Delete {
 			log.Error(err, "fetching cmstate has resulted in an error")
 			return nil, errors.Wrap(err, "fetching cmstate has resulted in an error")
 		}
+
+		// Leaving the audience only needs the cmstate, the template may be gone by now
+		if req.Operation == v1admission.Delete {
+			cmStates = append(cmStates, cmState)
+			continue
+		}
+
+		// Templates that cannot be read are not applied, their failure policy decides about the pod
+		skipTemplate := func(reason string) *admission.Response {
+			resp := hook.fail(pod, nil, reason, &warnings)
+			hook.recordDecision(req, pod, templateName, nil, nil, failOutcome(resp), reason)
+			return resp
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Error(err, "fetching cmstate has resulted in an error")
+			if resp := skipTemplate(fmt.Sprintf("fetching cmstate %s: %v", crdName, err)); resp != nil {
+				return resp, nil
+			}
+			continue
+		}
+
 		err = hook.Client.Get(
 			ctx,
 			types.NamespacedName{
-				Name: pod.Annotations["cache.spicedelver.me/cmtemplate"],
+				Name: templateName,
 			},
 			cmTemplate,
 		)
 
-		if err != nil && !apierrors.IsNotFound(err) {
-			log.Error(err, "fetching cmtemplate has resulted in an error")
-			return nil, errors.Wrap(err, "fetching cmtemplate has resulted in an error")
-		} else if err != nil {
-			log.Error(err, "fetching cmtemplate has resulted in an error")
-			return nil, errors.Wrap(err, "fetching cmtemplate has resulted in an error")
+		if err != nil {
+			reason := fmt.Sprintf("cmtemplate %s does not exist", templateName)
+			if !apierrors.IsNotFound(err) {
+				log.Error(err, "fetching cmtemplate has resulted in an error")
+				reason = fmt.Sprintf("fetching cmtemplate %s: %v", templateName, err)
+			}
+			if resp := skipTemplate(reason); resp != nil {
+				return resp, nil
+			}
+			continue
+		}
+
+		cmStates = append(cmStates, cmState)
+		cmTemplates = append(cmTemplates, cmTemplate)
+	}
+
+	switch req.Operation {
+	case v1admission.Create:
+		return hook.handlePodCreate(req, cmStates, cmTemplates, patch, namespace, warnings, ctx)
+	default:
+		return hook.handlePodDelete(req, cmStates, pod, ctx)
+	}
+}
+
+// fail applies the failure policy of a template that cannot be applied to the pod, or the
+// default policy when the template is nil. It returns the denial under the Deny policy,
+// otherwise the pod is admitted, with a warning unless the policy is AllowSilently, and
+// nil is returned.
+func (hook *cmStateCreator) fail(pod *corev1.Pod, cmTemplate *cachev1alpha1.CMTemplate, reason string, warnings *[]string) *admission.Response {
+	log := ctrl.Log.WithName("webhooks").WithName("CMStateCreator")
+
+	policy := hook.DefaultFailurePolicy
+	if cmTemplate != nil && cmTemplate.Spec.FailurePolicy != "" {
+		policy = cmTemplate.Spec.FailurePolicy
+	}
+	log.Info("cmtemplate cannot be applied", "reason", reason, "failurePolicy", policy)
+
+	switch policy {
+	case cachev1alpha1.FailurePolicyDeny:
+		return hook.deny(pod, reason)
+	case cachev1alpha1.FailurePolicyAllowSilently:
+		// admitted without telling the user
+	default:
+		*warnings = append(*warnings, reason)
+	}
+	return nil
+}
+
+// failPod applies the failure policies of the templates to a pod that none of them can be
+// applied to, e.g. because its namespace or workload cannot be read. Without templates
+// the default policy applies. The pod is denied when any of the policies denies it,
+// otherwise it is admitted without being injected.
+func (hook *cmStateCreator) failPod(req admission.Request, pod *corev1.Pod, cmTemplates []*cachev1alpha1.CMTemplate, reason string) *admission.Response {
+	if len(cmTemplates) == 0 {
+		cmTemplates = []*cachev1alpha1.CMTemplate{nil}
+	}
+	var warnings []string
+	var resp *admission.Response
+	for _, cmTemplate := range cmTemplates {
+		if resp = hook.fail(pod, cmTemplate, reason, &warnings); resp != nil {
+			break
 		}
+	}
+	hook.recordDecision(req, pod, "", nil, nil, failOutcome(resp), reason)
+	if resp != nil {
+		return resp
+	}
+	return hook.skip(pod, fmt.Sprintf("skipping cmstate injection: %s", reason), slices.Compact(warnings))
+}
 
-		switch req.Operation {
-		case v1admission.Create:
-			return hook.handlePodCreate(req, cmState, cmTemplate, pod, ctx)
-		case v1admission.Delete:
-			return hook.handlePodDelete(cmState, pod, ctx)
+// skip admits the pod without injecting it and records why, also when the failure
+// policies kept the reason out of the admission warnings.
+func (hook *cmStateCreator) skip(pod *corev1.Pod, reason string, warnings []string) *admission.Response {
+	hook.recordEvent(pod, ReasonInjectionSkipped, reason)
+	resp := admission.Allowed(reason).WithWarnings(warnings...)
+	return &resp
+}
+
+// selectTemplates returns the names of the CMTemplates whose selector matches the pod, sorted by name.
+// Templates whose selector fails are left out, one broken template must not fail every admission.
+func (hook *cmStateCreator) selectTemplates(ctx context.Context, namespace *corev1.Namespace, pod *corev1.Pod) ([]string, error) {
+	log := ctrl.Log.WithName("webhooks").WithName("CMStateCreator")
+
+	cmTemplates := &cachev1alpha1.CMTemplateList{}
+	if err := hook.Client.List(ctx, cmTemplates); err != nil {
+		return nil, err
+	}
+
+	var matched []string
+	for _, cmTemplate := range cmTemplates.Items {
+		if cmTemplate.Spec.Selector == nil {
+			continue
+		}
+		if allowed, _, err := cmTemplate.AllowsNamespace(namespace); !allowed || err != nil {
+			continue
+		}
+		ok, err := selectorMatches(cmTemplate.Spec.Selector, pod, namespace)
+		if err != nil {
+			log.Error(err, "skipping cmtemplate with a failing selector", "cmtemplate", cmTemplate.Name)
+			if hook.Recorder != nil {
+				hook.Recorder.Event(&cmTemplate, corev1.EventTypeWarning, ReasonSelectorFailed,
+					fmt.Sprintf("Selector failed for a pod in namespace %s: %v", namespace.Name, err))
+			}
+			continue
+		}
+		if ok {
+			matched = append(matched, cmTemplate.Name)
 		}
 	}
-	resp := admission.Allowed("skipping cmstate check due to missing annotation")
-	return &resp, nil
+	slices.Sort(matched)
+	return matched, nil
 }
 
-func (hook *cmStateCreator) handlePodDelete(cmState *cachev1alpha1.CMState, pod *corev1.Pod, ctx context.Context) (*admission.Response, error) {
-	if cmState.Name == "" {
+func (hook *cmStateCreator) handlePodDelete(req admission.Request, cmStates []*cachev1alpha1.CMState, pod *corev1.Pod, ctx context.Context) (*admission.Response, error) {
+	if !slices.ContainsFunc(cmStates, func(cmState *cachev1alpha1.CMState) bool { return cmState.Name != "" }) {
 		resp := admission.Allowed("skipping cmstate patch due to missing cmstate")
 		return &resp, nil
 	}
-	podName := pod.GetName()
-	if pod.GetGenerateName() != "" {
-		podName = pod.GetGenerateName()
-	}
 
-	if len(pod.GetOwnerReferences()) > 0 && hook.checkOwners(pod, ctx) {
-		resp := admission.Allowed("skipping cmstate patch due to pod being kept around")
-		return &resp, nil
+	member, err := hook.resolver().AudienceFor(ctx, req.Namespace, pod)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving pod workload has resulted in an error")
 	}
+	legacyMember := cachev1alpha1.CMAudience{Kind: workload.KindPod, Name: pod.GetGenerateName()}
+
+	update := leaveAudience(member, legacyMember)
+	patched := false
+	for _, cmState := range cmStates {
+		if cmState.Name == "" {
+			continue
+		}
+		if dryRun(req) {
+			_, changed := update(slices.Clone(cmState.Spec.Audience))
+			patched = patched || changed
+			continue
+		}
 
-	index := findIndex(cmState.Spec.Audience, podName)
-	if index == -1 {
+		changed, err := hook.patchAudience(ctx, cmState, update)
+		if err != nil {
+			resp := admission.Denied("patching cmstate has resulted in an error")
+			return &resp, err
+		}
+		if changed {
+			hook.recordDecision(req, pod, cmState.Spec.CMTemplate, nil, cmState, metrics.OutcomeReleased, "")
+		}
+		patched = patched || changed
+	}
+	if !patched {
 		resp := admission.Allowed("skipping cmstate patch due to pod not in audience")
 		return &resp, nil
 	}
-	cmState.Spec.Audience = append(cmState.Spec.Audience[:index], cmState.Spec.Audience[index+1:]...)
-
-	err := hook.Client.Patch(ctx, cmState, client.Merge)
-	if err != nil {
-		resp := admission.Denied("patching cmstate has resulted in an error")
-		return &resp, err
+	if dryRun(req) {
+		resp := admission.Allowed("skipping cmstate patch due to dry run")
+		return &resp, nil
 	}
 
 	resp := admission.Allowed("cmstate has been patched, no need to mutate pod")
 	return &resp, nil
 }
 
-func (hook *cmStateCreator) handlePodCreate(req admission.Request, cmState *cachev1alpha1.CMState, cmTemplate *cachev1alpha1.CMTemplate, pod *corev1.Pod, ctx context.Context) (*admission.Response, error) {
-	if cmState.Name == "" {
-		// create the cmstate
-		cmState = generateCMState(cmTemplate, pod)
+// injection is a template to inject into the pod being created.
+type injection struct {
+	cmTemplate *cachev1alpha1.CMTemplate
+	cmState    *cachev1alpha1.CMState
+	// reinvoked is set when an earlier invocation of the webhook already injected the template.
+	reinvoked bool
+	// created is set when the cmstate does not exist yet.
+	created bool
+	// outcome and message are the decision recorded once the template is injected.
+	outcome, message string
+}
 
-		err := hook.Client.Create(ctx, cmState)
+func (hook *cmStateCreator) handlePodCreate(req admission.Request, cmStates []*cachev1alpha1.CMState, cmTemplates []*cachev1alpha1.CMTemplate, patch *podPatch, namespace *corev1.Namespace, warnings []string, ctx context.Context) (*admission.Response, error) {
+	log := ctrl.Log.WithName("webhooks").WithName("CMStateCreator")
 
-		if err != nil {
-			resp := admission.Denied("creating cmstate has resulted in an error")
-			return &resp, err
-		}
+	pod := patch.pod
+	if err := checkTemplateConflicts(cmTemplates); err != nil {
+		return hook.deny(pod, err.Error()), nil
 	}
 
-	pod.Annotations[cmTemplate.Spec.Template.TargetAnnotation] = cmState.Name
-
-	pData, err := json.Marshal(pod)
+	member, err := hook.resolver().AudienceFor(ctx, req.Namespace, pod)
 	if err != nil {
-		return nil, errors.Wrap(err, "error encoding response object")
+		log.Error(err, "resolving pod workload has resulted in an error")
+		return hook.failPod(req, pod, cmTemplates, fmt.Sprintf("resolving pod workload: %v", err)), nil
 	}
 
-	resp := admission.PatchResponseFromRaw(req.Object.Raw, pData)
+	// Every template is checked before anything is written, a denied pod must not leave
+	// cmstates or audience entries behind
+	injections, denied, err := hook.planInjections(req, cmStates, cmTemplates, pod, namespace, member, &warnings)
+	if denied != nil || err != nil {
+		return denied, err
+	}
+
+	var applied []*cachev1alpha1.CMState
+	var injected []injection
+	for _, injection := range injections {
+		if !injection.reinvoked && !dryRun(req) {
+			changed, err := hook.applyInjection(ctx, injection, member)
+			if err != nil {
+				reason := fmt.Sprintf("patching cmstate %s: %v", injection.cmState.Name, err)
+				if injection.created {
+					reason = fmt.Sprintf("creating cmstate %s: %v", injection.cmState.Name, err)
+				}
+				if resp := hook.fail(pod, injection.cmTemplate, reason, &warnings); resp != nil {
+					// Undo the templates applied so far, the pod never runs
+					for _, cmState := range applied {
+						if _, err := hook.patchAudience(ctx, cmState, dropMember(member)); err != nil {
+							log.Error(err, "releasing the audience of a denied pod has resulted in an error", "cmstate", cmState.Name)
+						}
+					}
+					hook.recordDecision(req, pod, injection.cmTemplate.Name, injection.cmTemplate, injection.cmState, metrics.OutcomeDenied, reason)
+					return resp, nil
+				}
+				hook.recordDecision(req, pod, injection.cmTemplate.Name, injection.cmTemplate, injection.cmState, metrics.OutcomeFailed, reason)
+				continue
+			}
+			if changed {
+				applied = append(applied, injection.cmState)
+			}
+		}
+
+		if !injection.reinvoked {
+			patch.setAnnotation(injection.cmTemplate.Spec.Template.TargetAnnotation, injection.cmState.Name)
+		}
+		injectConfigMap(patch, injection.cmTemplate, injection.cmState.Name)
+		injected = append(injected, injection)
+		hook.previewCMState(injection.cmState, injection.created, member)
+	}
+	if len(injected) == 0 {
+		reason := "skipping cmstate injection due to failed cmtemplates"
+		if len(warnings) > 0 {
+			reason = fmt.Sprintf("skipping cmstate injection: %s", strings.Join(warnings, "; "))
+		}
+		return hook.skip(pod, reason, warnings), nil
+	}
+	if len(warnings) > 0 {
+		hook.recordEvent(pod, ReasonInjectionDegraded, fmt.Sprintf("cmstate injection incomplete: %s", strings.Join(warnings, "; ")))
+	}
+
+	names := make([]string, 0, len(injected))
+	for _, injection := range injected {
+		names = append(names, injection.cmTemplate.Name)
+		if !injection.reinvoked {
+			hook.recordDecision(req, pod, injection.cmTemplate.Name, injection.cmTemplate, injection.cmState, injection.outcome, injection.message)
+		}
+	}
+	patch.setAnnotation(InjectedAnnotation, strings.Join(names, ","))
+	resp := patch.response("cmstates have been injected").WithWarnings(warnings...)
 	return &resp, nil
 }
 
-// Generating a CMState used for later
-func generateCMState(cmTemplate *cachev1alpha1.CMTemplate, pod *corev1.Pod) *cachev1alpha1.CMState {
-	annotations := pod.GetAnnotations()
+// planInjections checks the namespace policy and the values of every template against
+// the pod, without writing anything. It returns the templates to inject, or the denial
+// of the pod. Templates left out under their failure policy add to the warnings.
+func (hook *cmStateCreator) planInjections(req admission.Request, cmStates []*cachev1alpha1.CMState, cmTemplates []*cachev1alpha1.CMTemplate,
+	pod *corev1.Pod, namespace *corev1.Namespace, member cachev1alpha1.CMAudience, warnings *[]string) ([]injection, *admission.Response, error) {
+	reinvoked := strings.Split(pod.GetAnnotations()[InjectedAnnotation], ",")
+	injections := make([]injection, 0, len(cmTemplates))
+	for i, cmTemplate := range cmTemplates {
+		cmState := cmStates[i]
+
+		if slices.Contains(reinvoked, cmTemplate.Name) && cmState.Name != "" {
+			injections = append(injections, injection{cmTemplate: cmTemplate, cmState: cmState, reinvoked: true})
+			continue
+		}
+		decide := func(resp *admission.Response, reason string) *admission.Response {
+			hook.recordDecision(req, pod, cmTemplate.Name, cmTemplate, cmState, failOutcome(resp), reason)
+			return resp
+		}
 
-	labels := make(map[string]string)
-	for annotation := range cmTemplate.Spec.Template.AnnotationReplace {
-		labels[annotation] = annotations[annotation]
+		allowed, reason, err := cmTemplate.AllowsNamespace(namespace)
+		if err != nil {
+			reason := fmt.Sprintf("checking namespace %s against cmtemplate %s: %v", namespace.Name, cmTemplate.Name, err)
+			if resp := decide(hook.fail(pod, cmTemplate, reason, warnings), reason); resp != nil {
+				return nil, resp, nil
+			}
+			continue
+		}
+		if !allowed {
+			if cmTemplate.Spec.NamespacePolicy == cachev1alpha1.NamespacePolicyDeny {
+				return nil, decide(hook.deny(pod, reason), reason), nil
+			}
+			hook.recordDecision(req, pod, cmTemplate.Name, cmTemplate, nil, metrics.OutcomeSkipped, reason)
+			*warnings = append(*warnings, reason)
+			continue
+		}
+
+		planned := injection{cmTemplate: cmTemplate, cmState: cmState, outcome: metrics.OutcomeInjected}
+		if cmState.Name == "" {
+			var missing []string
+			planned.created = true
+			planned.cmState, missing, err = generateCMState(cmTemplate, pod, namespace, member)
+			if err != nil {
+				reason := fmt.Sprintf("resolving values of cmtemplate %s: %v", cmTemplate.Name, err)
+				if resp := decide(hook.fail(pod, cmTemplate, reason, warnings), reason); resp != nil {
+					return nil, resp, nil
+				}
+				continue
+			}
+			if len(missing) > 0 {
+				// Admitted pods get the template rendered with the missing values left empty
+				reason := fmt.Sprintf("cmtemplate %s is missing values: %s", cmTemplate.Name, strings.Join(missing, ", "))
+				if resp := hook.fail(pod, cmTemplate, reason, warnings); resp != nil {
+					return nil, decide(resp, reason), nil
+				}
+				planned.outcome, planned.message = metrics.OutcomeDegraded, reason
+			}
+		}
+		injections = append(injections, planned)
+	}
+	return injections, nil, nil
+}
+
+// applyInjection creates the cmstate of the injection or adds the pod to its audience. It
+// reports whether the audience changed.
+func (hook *cmStateCreator) applyInjection(ctx context.Context, injection injection, member cachev1alpha1.CMAudience) (bool, error) {
+	if injection.created {
+		return hook.createCMState(ctx, injection.cmState, member)
+	}
+	return hook.patchAudience(ctx, injection.cmState, joinAudience(member))
+}
+
+// Generating a CMState used for later, together with the names of the values the pod does not provide
+func generateCMState(cmTemplate *cachev1alpha1.CMTemplate, pod *corev1.Pod, namespace *corev1.Namespace, member cachev1alpha1.CMAudience) (*cachev1alpha1.CMState, []string, error) {
+	values, missing, err := podValues(cmTemplate, pod, namespace)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	podName := pod.GetName()
-	if podName == "" {
-		podName = pod.GetGenerateName()
+	annotations := pod.GetAnnotations()
+	for annotation := range cmTemplate.Spec.Template.AnnotationReplace {
+		value, ok := annotations[annotation]
+		if !ok {
+			missing = append(missing, annotation)
+		}
+		values[annotation] = value
 	}
+
 	return &cachev1alpha1.CMState{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "cache.spicedelver.me/v1alpha1",
 			Kind:       "CMState",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      generateName(cmTemplate.Name),
+			Name:      CMStateName(cmTemplate.Name),
 			Namespace: pod.GetNamespace(),
-			Labels:    labels,
 		},
 		Spec: cachev1alpha1.CMStateSpec{
-			Audience: []cachev1alpha1.CMAudience{
-				{
-					Kind: "Pod",
-					Name: podName,
-				},
-			},
+			Audience:   []cachev1alpha1.CMAudience{member},
 			CMTemplate: cmTemplate.Name,
+			Values:     values,
 		},
-	}
+	}, missing, nil
 }
 
-func generateName(cmTemplateName string) string {
-	return strings.ToLower(strings.ReplaceAll(fmt.Sprintf("cmstate-%s", cmTemplateName), "_", "-"))
+// dryRun reports whether the request must not persist anything. The pod mutation
+// is still computed in full, only the cmstates are left untouched.
+func dryRun(req admission.Request) bool {
+	return req.DryRun != nil && *req.DryRun
 }
 
-func findIndex(slice []cachev1alpha1.CMAudience, name string) int {
-	for i, aud := range slice {
-		if aud.Name == name {
-			return i
-		}
-	}
-	return -1
+// CMStateName returns the name of the CMState, and its ConfigMap, of a template in a namespace.
+func CMStateName(cmTemplateName string) string {
+	return strings.ToLower(strings.ReplaceAll(fmt.Sprintf("cmstate-%s", cmTemplateName), "_", "-"))
 }
 
-func (hook *cmStateCreator) checkOwners(pod *corev1.Pod, ctx context.Context) bool {
-	for _, owner := range pod.GetOwnerReferences() {
-		if !hook.checkOwner(owner, pod, ctx) {
-			return false
-		}
-	}
-	return true
+func findIndex(slice []cachev1alpha1.CMAudience, member cachev1alpha1.CMAudience) int {
+	return slices.Index(slice, member)
 }
-
-func (hook *cmStateCreator) checkOwner(owner metav1.OwnerReference, pod *corev1.Pod, ctx context.Context) bool {
-	switch owner.Kind {
-	case "Deployment":
-		ownerObject := &appsv1.Deployment{}
-		err := hook.Client.Get(
-			ctx,
-			types.NamespacedName{
-				Namespace: pod.Namespace,
-				Name:      owner.Name,
-			},
-			ownerObject,
-		)
-		if err != nil {
-			fmt.Println(err)
-			return false
-		}
-		return *ownerObject.Spec.Replicas != 0
-	case "DaemonSet":
-		ownerObject := &appsv1.DaemonSet{}
-		err := hook.Client.Get(
-			ctx,
-			types.NamespacedName{
-				Namespace: pod.Namespace,
-				Name:      owner.Name,
-			},
-			ownerObject,
-		)
-		if err != nil {
-			fmt.Println(err)
-			return false
-		}
-		return true
-	case "StatefulSet":
-		ownerObject := &appsv1.StatefulSet{}
-		err := hook.Client.Get(
-			ctx,
-			types.NamespacedName{
-				Namespace: pod.Namespace,
-				Name:      owner.Name,
-			},
-			ownerObject,
-		)
-		if err != nil {
-			fmt.Println(err)
-			return false
-		}
-		return *ownerObject.Spec.Replicas != 0
-	case "ReplicaSet":
-		ownerObject := &appsv1.ReplicaSet{}
-		err := hook.Client.Get(
-			ctx,
-			types.NamespacedName{
-				Namespace: pod.Namespace,
-				Name:      owner.Name,
-			},
-			ownerObject,
-		)
-		if err != nil {
-			fmt.Println(err)
-			return false
-		}
-		return *ownerObject.Spec.Replicas != 0
-	}
-	return false
-}
-
-// err = hook.Client.Get(
-// 	ctx,
-// 	types.NamespacedName{
-// 		Name: pod.Annotations["cache.spicedelver.me/cmtemplate"],
-// 	},
-// 	cmTemplate,
-// )
@@ -0,0 +1,901 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	jsonpatchv5 "github.com/evanphx/json-patch/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	"github.com/stollenaar/cmstate-injector-operator/internal/audit"
+	"github.com/stollenaar/cmstate-injector-operator/internal/metrics"
+	"gomodules.xyz/jsonpatch/v2"
+	v1admission "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func podRequest(operation v1admission.Operation, pod *corev1.Pod) admission.Request {
+	raw, err := json.Marshal(pod)
+	Expect(err).NotTo(HaveOccurred())
+
+	req := admission.Request{AdmissionRequest: v1admission.AdmissionRequest{
+		Operation: operation,
+		Namespace: pod.Namespace,
+	}}
+	if operation == v1admission.Delete {
+		req.OldObject = runtime.RawExtension{Raw: raw}
+	} else {
+		req.Object = runtime.RawExtension{Raw: raw}
+	}
+	return req
+}
+
+func updateRequest(oldPod, pod *corev1.Pod) admission.Request {
+	req := podRequest(v1admission.Update, pod)
+	raw, err := json.Marshal(oldPod)
+	Expect(err).NotTo(HaveOccurred())
+	req.OldObject = runtime.RawExtension{Raw: raw}
+	return req
+}
+
+var _ = Describe("CMState Webhook", func() {
+	const templateName = "test-resource"
+
+	var (
+		ctx       context.Context
+		k8sClient client.Client
+		hook      *cmStateCreator
+	)
+
+	cmStateName := types.NamespacedName{Name: CMStateName(templateName), Namespace: "default"}
+
+	newPod := func(generateName string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: generateName,
+				Namespace:    "default",
+				Annotations:  map[string]string{TemplateAnnotation: templateName},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}},
+		}
+	}
+
+	newClient := func(funcs interceptor.Funcs) client.Client {
+		return fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithInterceptorFuncs(funcs).
+			WithObjects(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}, &cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: templateName},
+				Spec: cachev1alpha1.CMTemplateSpec{
+					Template: cachev1alpha1.Template{
+						CMTemplate:       map[string]string{"config": "static"},
+						TargetAnnotation: "test/target",
+						MountPath:        "/etc/config",
+					},
+				},
+			}).
+			Build()
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		k8sClient = newClient(interceptor.Funcs{})
+		hook = &cmStateCreator{Client: k8sClient, DefaultFailurePolicy: cachev1alpha1.FailurePolicyDeny}
+	})
+
+	Context("When pods of a template are created", func() {
+		It("should create the cmstate and mount its configmap", func() {
+			recorder := record.NewFakeRecorder(10)
+			hook.Recorder = recorder
+
+			resp := hook.Handle(ctx, podRequest(v1admission.Create, newPod("app-")))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Patches).NotTo(BeEmpty())
+			Expect(recorder.Events).To(Receive(ContainSubstring(cachev1alpha1.EventReasonCMStateCreated)))
+
+			cmState := &cachev1alpha1.CMState{}
+			Expect(k8sClient.Get(ctx, cmStateName, cmState)).To(Succeed())
+			Expect(cmState.Spec.Audience).To(ConsistOf(cachev1alpha1.CMAudience{Kind: "Pod", Name: "app-"}))
+		})
+
+		It("should patch only what it injects", func() {
+			Expect(k8sClient.Create(ctx, &cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "selected"},
+				Spec: cachev1alpha1.CMTemplateSpec{
+					Template: cachev1alpha1.Template{
+						CMTemplate:       map[string]string{"config": "static"},
+						TargetAnnotation: "test/selected",
+						EnvFrom:          true,
+					},
+					Selector: &cachev1alpha1.TemplateSelector{},
+				},
+			})).To(Succeed())
+
+			// Selected pods may come without any annotations
+			pod := newPod("app-")
+			pod.Annotations = nil
+			req := podRequest(v1admission.Create, pod)
+
+			resp := hook.Handle(ctx, req)
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Patches).To(ConsistOf(
+				jsonpatch.NewOperation("add", "/metadata/annotations", map[string]string{}),
+				jsonpatch.NewOperation("add", "/metadata/annotations/cache.spicedelver.me~1cmtemplate", "selected"),
+				jsonpatch.NewOperation("add", "/metadata/annotations/test~1selected", "cmstate-selected"),
+				jsonpatch.NewOperation("add", "/spec/containers/0/envFrom", []any{corev1.EnvFromSource{
+					ConfigMapRef: &corev1.ConfigMapEnvSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "cmstate-selected"},
+					},
+				}}),
+				jsonpatch.NewOperation("add", "/metadata/annotations/cache.spicedelver.me~1injected", "selected"),
+			))
+
+			By("Applying the patch to the pod")
+			ops, err := json.Marshal(resp.Patches)
+			Expect(err).NotTo(HaveOccurred())
+			decoded, err := jsonpatchv5.DecodePatch(ops)
+			Expect(err).NotTo(HaveOccurred())
+			patched, err := decoded.Apply(req.Object.Raw)
+			Expect(err).NotTo(HaveOccurred())
+
+			result := &corev1.Pod{}
+			Expect(json.Unmarshal(patched, result)).To(Succeed())
+			Expect(result.Annotations).To(HaveKeyWithValue("test/selected", "cmstate-selected"))
+			Expect(result.Spec.Containers[0].EnvFrom).To(HaveLen(1))
+		})
+
+		It("should skip selected templates whose selector fails", func() {
+			recorder := record.NewFakeRecorder(10)
+			hook.Recorder = recorder
+			for name, expression := range map[string]string{"broken": `object.spec.missing == "x"`, "selected": `true`} {
+				Expect(k8sClient.Create(ctx, &cachev1alpha1.CMTemplate{
+					ObjectMeta: metav1.ObjectMeta{Name: name},
+					Spec: cachev1alpha1.CMTemplateSpec{
+						Template: cachev1alpha1.Template{
+							CMTemplate:       map[string]string{"config": "static"},
+							TargetAnnotation: "test/" + name,
+							EnvFrom:          true,
+						},
+						Selector: &cachev1alpha1.TemplateSelector{MatchConditions: []cachev1alpha1.MatchCondition{
+							{Name: "condition", Expression: expression},
+						}},
+					},
+				})).To(Succeed())
+			}
+
+			pod := newPod("app-")
+			pod.Annotations = nil
+			resp := hook.Handle(ctx, podRequest(v1admission.Create, pod))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Patches).To(ContainElement(
+				jsonpatch.NewOperation("add", "/metadata/annotations/cache.spicedelver.me~1cmtemplate", "selected")))
+			Expect(recorder.Events).To(Receive(ContainSubstring(ReasonSelectorFailed)))
+		})
+
+		It("should only inject new containers when reinvoked", func() {
+			pod := newPod("app-")
+			resp := hook.Handle(ctx, podRequest(v1admission.Create, pod))
+			Expect(resp.Allowed).To(BeTrue())
+
+			By("Reinvoking the webhook after another webhook added a container")
+			ops, err := json.Marshal(resp.Patches)
+			Expect(err).NotTo(HaveOccurred())
+			decoded, err := jsonpatchv5.DecodePatch(ops)
+			Expect(err).NotTo(HaveOccurred())
+			raw, err := json.Marshal(pod)
+			Expect(err).NotTo(HaveOccurred())
+			patched, err := decoded.Apply(raw)
+			Expect(err).NotTo(HaveOccurred())
+			reinvoked := &corev1.Pod{}
+			Expect(json.Unmarshal(patched, reinvoked)).To(Succeed())
+			reinvoked.Spec.Containers = append(reinvoked.Spec.Containers, corev1.Container{Name: "sidecar", Image: "proxy"})
+
+			resp = hook.Handle(ctx, podRequest(v1admission.Create, reinvoked))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Patches).To(ConsistOf(
+				jsonpatch.NewOperation("add", "/spec/containers/1/volumeMounts", []any{corev1.VolumeMount{
+					Name: "cmstate-test-resource", MountPath: "/etc/config", ReadOnly: true,
+				}}),
+			))
+
+			cmState := &cachev1alpha1.CMState{}
+			Expect(k8sClient.Get(ctx, cmStateName, cmState)).To(Succeed())
+			Expect(cmState.Spec.Audience).To(HaveLen(1))
+		})
+
+		It("should audit who got which cmstate", func() {
+			out := &bytes.Buffer{}
+			hook.Audit = audit.NewLogger(out)
+
+			req := podRequest(v1admission.Create, newPod("app-"))
+			req.UID = "request-uid"
+			req.UserInfo = authenticationv1.UserInfo{Username: "system:serviceaccount:kube-system:replicaset-controller"}
+			Expect(hook.Handle(ctx, req).Allowed).To(BeTrue())
+
+			record := audit.Record{}
+			Expect(json.Unmarshal(out.Bytes(), &record)).To(Succeed())
+			Expect(record.Kind).To(Equal(audit.KindAdmission))
+			Expect(record.RequestUID).To(Equal("request-uid"))
+			Expect(record.UserInfo.Username).To(Equal("system:serviceaccount:kube-system:replicaset-controller"))
+			Expect(record.Pod).To(Equal("app-"))
+			Expect(record.Template).To(Equal(templateName))
+			Expect(record.CMState).To(Equal(cmStateName.Name))
+			Expect(record.Outcome).To(Equal("injected"))
+		})
+
+		It("should not persist anything on dry-run", func() {
+			req := podRequest(v1admission.Create, newPod("app-"))
+			req.DryRun = ptr.To(true)
+
+			resp := hook.Handle(ctx, req)
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Patches).NotTo(BeEmpty())
+
+			cmStates := &cachev1alpha1.CMStateList{}
+			Expect(k8sClient.List(ctx, cmStates)).To(Succeed())
+			Expect(cmStates.Items).To(BeEmpty())
+		})
+
+		It("should preview the cmstates a pod would join or create", func() {
+			preview, err := PreviewPodCreate(ctx, k8sClient, cachev1alpha1.FailurePolicyDeny, nil, newPod("app-"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preview.Response.Allowed).To(BeTrue())
+			Expect(preview.Response.Patches).NotTo(BeEmpty())
+			Expect(preview.CMStates).To(HaveLen(1))
+			Expect(preview.CMStates[0].Created).To(BeTrue())
+			Expect(preview.CMStates[0].CMState.Spec.Audience).To(ConsistOf(cachev1alpha1.CMAudience{Kind: "Pod", Name: "app-"}))
+
+			cmStates := &cachev1alpha1.CMStateList{}
+			Expect(k8sClient.List(ctx, cmStates)).To(Succeed())
+			Expect(cmStates.Items).To(BeEmpty())
+
+			By("Previewing a pod joining an existing cmstate")
+			Expect(hook.Handle(ctx, podRequest(v1admission.Create, newPod("web-"))).Allowed).To(BeTrue())
+			preview, err = PreviewPodCreate(ctx, k8sClient, cachev1alpha1.FailurePolicyDeny, nil, newPod("app-"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preview.CMStates).To(HaveLen(1))
+			Expect(preview.CMStates[0].Created).To(BeFalse())
+			Expect(preview.CMStates[0].CMState.Spec.Audience).To(ConsistOf(
+				cachev1alpha1.CMAudience{Kind: "Pod", Name: "web-"}, cachev1alpha1.CMAudience{Kind: "Pod", Name: "app-"}))
+
+			cmState := &cachev1alpha1.CMState{}
+			Expect(k8sClient.Get(ctx, cmStateName, cmState)).To(Succeed())
+			Expect(cmState.Spec.Audience).To(ConsistOf(cachev1alpha1.CMAudience{Kind: "Pod", Name: "web-"}))
+		})
+
+		It("should deny pods of a missing template under the Deny policy", func() {
+			pod := newPod("app-")
+			pod.Annotations[TemplateAnnotation] = "missing"
+
+			resp := hook.Handle(ctx, podRequest(v1admission.Create, pod))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Message).To(ContainSubstring("cmtemplate missing does not exist"))
+		})
+
+		It("should not count decisions under the names of missing templates", func() {
+			decisions := func(template string) float64 {
+				return testutil.ToFloat64(metrics.AdmissionDecisions.WithLabelValues(string(v1admission.Create), metrics.OutcomeDenied, template))
+			}
+			unknown := decisions(metrics.UnknownTemplate)
+
+			pod := newPod("app-")
+			pod.Annotations[TemplateAnnotation] = "made-up-by-the-pod"
+			Expect(hook.Handle(ctx, podRequest(v1admission.Create, pod)).Allowed).To(BeFalse())
+			Expect(decisions(metrics.UnknownTemplate)).To(Equal(unknown + 1))
+			Expect(metrics.AdmissionDecisions.DeleteLabelValues(
+				string(v1admission.Create), metrics.OutcomeDenied, "made-up-by-the-pod")).To(BeFalse(), "the series must not exist")
+		})
+	})
+
+	Context("When a pod asks for several templates", func() {
+		const secondName = "second"
+
+		var createErr error
+
+		BeforeEach(func() {
+			createErr = nil
+			k8sClient = newClient(interceptor.Funcs{
+				Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+					if obj.GetName() == CMStateName(secondName) && createErr != nil {
+						return createErr
+					}
+					return c.Create(ctx, obj, opts...)
+				},
+			})
+			hook = &cmStateCreator{Client: k8sClient, DefaultFailurePolicy: cachev1alpha1.FailurePolicyDeny}
+			Expect(k8sClient.Create(ctx, &cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: secondName},
+				Spec: cachev1alpha1.CMTemplateSpec{
+					Template: cachev1alpha1.Template{
+						CMTemplate:        map[string]string{"config": "role=${role}"},
+						AnnotationReplace: map[string]string{"role": "${role}"},
+						TargetAnnotation:  "test/second",
+						MountPath:         "/etc/second",
+					},
+				},
+			})).To(Succeed())
+		})
+
+		newMultiPod := func() *corev1.Pod {
+			pod := newPod("app-")
+			pod.Annotations[TemplateAnnotation] = templateName + "," + secondName
+			pod.Annotations["role"] = "reader"
+			return pod
+		}
+
+		It("should inject every template", func() {
+			resp := hook.Handle(ctx, podRequest(v1admission.Create, newMultiPod()))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Patches).To(ContainElement(
+				jsonpatch.NewOperation("add", "/metadata/annotations/cache.spicedelver.me~1injected", templateName+","+secondName)))
+
+			cmStates := &cachev1alpha1.CMStateList{}
+			Expect(k8sClient.List(ctx, cmStates)).To(Succeed())
+			Expect(cmStates.Items).To(HaveLen(2))
+		})
+
+		It("should write nothing when a later template denies the pod", func() {
+			pod := newMultiPod()
+			delete(pod.Annotations, "role")
+
+			resp := hook.Handle(ctx, podRequest(v1admission.Create, pod))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Message).To(ContainSubstring("cmtemplate second is missing values: role"))
+
+			cmStates := &cachev1alpha1.CMStateList{}
+			Expect(k8sClient.List(ctx, cmStates)).To(Succeed())
+			Expect(cmStates.Items).To(BeEmpty())
+		})
+
+		It("should release the audiences joined before a write denies the pod", func() {
+			createErr = fmt.Errorf("etcd is unavailable")
+
+			resp := hook.Handle(ctx, podRequest(v1admission.Create, newMultiPod()))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Message).To(ContainSubstring("creating cmstate cmstate-second"))
+
+			cmState := &cachev1alpha1.CMState{}
+			Expect(k8sClient.Get(ctx, cmStateName, cmState)).To(Succeed())
+			Expect(cmState.Spec.Audience).To(BeEmpty())
+		})
+
+		It("should keep the templates it could inject under an allowing policy", func() {
+			createErr = fmt.Errorf("etcd is unavailable")
+			hook.DefaultFailurePolicy = cachev1alpha1.FailurePolicyAllowWithWarning
+
+			resp := hook.Handle(ctx, podRequest(v1admission.Create, newMultiPod()))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Warnings).To(ConsistOf(ContainSubstring("creating cmstate cmstate-second")))
+
+			cmState := &cachev1alpha1.CMState{}
+			Expect(k8sClient.Get(ctx, cmStateName, cmState)).To(Succeed())
+			Expect(cmState.Spec.Audience).To(ConsistOf(cachev1alpha1.CMAudience{Kind: "Pod", Name: "app-"}))
+		})
+	})
+
+	Context("When admission is a dry-run", func() {
+		var recorder *record.FakeRecorder
+
+		BeforeEach(func() {
+			recorder = record.NewFakeRecorder(10)
+			hook.Recorder = recorder
+		})
+
+		dryRunRequest := func(req admission.Request) admission.Request {
+			req.DryRun = ptr.To(true)
+			return req
+		}
+		audience := func() []cachev1alpha1.CMAudience {
+			cmState := &cachev1alpha1.CMState{}
+			Expect(k8sClient.Get(ctx, cmStateName, cmState)).To(Succeed())
+			return cmState.Spec.Audience
+		}
+
+		It("should return the full mutation without creating the cmstate", func() {
+			out := &bytes.Buffer{}
+			hook.Audit = audit.NewLogger(out)
+
+			resp := hook.Handle(ctx, dryRunRequest(podRequest(v1admission.Create, newPod("app-"))))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Patches).To(Equal(hook.Handle(ctx, podRequest(v1admission.Create, newPod("app-"))).Patches))
+			Expect(recorder.Events).To(HaveLen(1), "only the real admission records events")
+
+			record := audit.Record{}
+			Expect(json.NewDecoder(out).Decode(&record)).To(Succeed())
+			Expect(record.DryRun).To(BeTrue())
+		})
+
+		It("should not join the audience of an existing cmstate", func() {
+			Expect(hook.Handle(ctx, podRequest(v1admission.Create, newPod("app-"))).Allowed).To(BeTrue())
+
+			resp := hook.Handle(ctx, dryRunRequest(podRequest(v1admission.Create, newPod("web-"))))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Patches).NotTo(BeEmpty())
+			Expect(audience()).To(ConsistOf(cachev1alpha1.CMAudience{Kind: "Pod", Name: "app-"}))
+		})
+
+		It("should not leave the audience when the pod is deleted", func() {
+			Expect(hook.Handle(ctx, podRequest(v1admission.Create, newPod("app-"))).Allowed).To(BeTrue())
+
+			resp := hook.Handle(ctx, dryRunRequest(podRequest(v1admission.Delete, newPod("app-"))))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Result.Message).To(ContainSubstring("dry run"))
+			Expect(audience()).To(ConsistOf(cachev1alpha1.CMAudience{Kind: "Pod", Name: "app-"}))
+		})
+
+		It("should not move the pod when its templates change", func() {
+			Expect(hook.Handle(ctx, podRequest(v1admission.Create, newPod("app-"))).Allowed).To(BeTrue())
+			running := newPod("app-")
+			running.Name, running.UID = "app-x1", "uid"
+			running.Annotations[InjectedAnnotation] = templateName
+			pod := running.DeepCopy()
+			pod.Annotations[TemplateAnnotation] = "none-left"
+
+			resp := hook.Handle(ctx, dryRunRequest(updateRequest(running, pod)))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Warnings).NotTo(BeEmpty())
+			Expect(audience()).To(ConsistOf(cachev1alpha1.CMAudience{Kind: "Pod", Name: "app-"}))
+		})
+	})
+
+	Context("When the pod cannot be looked up", func() {
+		var failNamespace, failWorkload bool
+
+		BeforeEach(func() {
+			failNamespace, failWorkload = false, false
+			k8sClient = newClient(interceptor.Funcs{
+				Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+					switch obj.(type) {
+					case *corev1.Namespace:
+						if failNamespace {
+							return fmt.Errorf("apiserver is unavailable")
+						}
+					case *appsv1.ReplicaSet:
+						if failWorkload {
+							return fmt.Errorf("apiserver is unavailable")
+						}
+					}
+					return c.Get(ctx, key, obj, opts...)
+				},
+			})
+			hook = &cmStateCreator{Client: k8sClient, DefaultFailurePolicy: cachev1alpha1.FailurePolicyDeny}
+		})
+
+		setFailurePolicy := func(policy cachev1alpha1.FailurePolicy) {
+			cmTemplate := &cachev1alpha1.CMTemplate{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: templateName}, cmTemplate)).To(Succeed())
+			cmTemplate.Spec.FailurePolicy = policy
+			Expect(k8sClient.Update(ctx, cmTemplate)).To(Succeed())
+		}
+
+		ownedPod := func() *corev1.Pod {
+			pod := newPod("app-1-")
+			pod.OwnerReferences = []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app-1", Controller: ptr.To(true),
+			}}
+			return pod
+		}
+
+		It("should deny the pod when its namespace cannot be read under the Deny policy", func() {
+			failNamespace = true
+
+			resp := hook.Handle(ctx, podRequest(v1admission.Create, newPod("app-")))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Code).To(Equal(int32(http.StatusForbidden)))
+			Expect(resp.Result.Message).To(ContainSubstring("fetching namespace default"))
+		})
+
+		It("should admit the pod with a warning when its namespace cannot be read", func() {
+			failNamespace = true
+			hook.DefaultFailurePolicy = cachev1alpha1.FailurePolicyAllowWithWarning
+
+			resp := hook.Handle(ctx, podRequest(v1admission.Create, newPod("app-")))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Patches).To(BeEmpty())
+			Expect(resp.Warnings).To(ConsistOf(ContainSubstring("fetching namespace default")))
+		})
+
+		It("should apply the policy of the template when the workload cannot be resolved", func() {
+			failWorkload = true
+
+			resp := hook.Handle(ctx, podRequest(v1admission.Create, ownedPod()))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Message).To(ContainSubstring("resolving pod workload"))
+
+			setFailurePolicy(cachev1alpha1.FailurePolicyAllowSilently)
+			resp = hook.Handle(ctx, podRequest(v1admission.Create, ownedPod()))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Patches).To(BeEmpty())
+			Expect(resp.Warnings).To(BeEmpty())
+
+			cmStates := &cachev1alpha1.CMStateList{}
+			Expect(k8sClient.List(ctx, cmStates)).To(Succeed())
+			Expect(cmStates.Items).To(BeEmpty())
+		})
+	})
+
+	Context("When the templates of a running pod change", func() {
+		var (
+			recorder *record.FakeRecorder
+			running  *corev1.Pod
+		)
+
+		BeforeEach(func() {
+			Expect(k8sClient.Create(ctx, &cachev1alpha1.CMTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "other"},
+				Spec: cachev1alpha1.CMTemplateSpec{
+					Template: cachev1alpha1.Template{
+						CMTemplate:       map[string]string{"config": "static"},
+						TargetAnnotation: "test/other",
+						MountPath:        "/etc/other",
+					},
+				},
+			})).To(Succeed())
+			running = newPod("app-")
+			running.Annotations[InjectedAnnotation] = templateName
+			Expect(hook.Handle(ctx, podRequest(v1admission.Create, newPod("app-"))).Allowed).To(BeTrue())
+			running.Name, running.UID = "app-x1", "uid"
+
+			recorder = record.NewFakeRecorder(10)
+			hook.Recorder = recorder
+		})
+
+		events := func() []string {
+			var recorded []string
+			for len(recorder.Events) > 0 {
+				recorded = append(recorded, <-recorder.Events)
+			}
+			return recorded
+		}
+
+		It("should move the pod to the cmstate of its new template", func() {
+			pod := running.DeepCopy()
+			pod.Annotations[TemplateAnnotation] = "other"
+
+			resp := hook.Handle(ctx, updateRequest(running, pod))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Warnings).To(HaveLen(2))
+			Expect(events()).To(ContainElement(ContainSubstring(ReasonTemplateMismatch)))
+
+			cmState := &cachev1alpha1.CMState{}
+			Expect(k8sClient.Get(ctx, cmStateName, cmState)).To(Succeed())
+			Expect(cmState.Spec.Audience).To(BeEmpty())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: CMStateName("other"), Namespace: "default"}, cmState)).To(Succeed())
+			Expect(cmState.Spec.Audience).To(ConsistOf(cachev1alpha1.CMAudience{Kind: "Pod", Name: "app-"}))
+		})
+
+		It("should remove the pod from the audience when its template is removed", func() {
+			pod := running.DeepCopy()
+			delete(pod.Annotations, TemplateAnnotation)
+
+			resp := hook.Handle(ctx, updateRequest(running, pod))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(events()).To(ContainElement(ContainSubstring("no longer uses cmtemplate test-resource")))
+
+			cmState := &cachev1alpha1.CMState{}
+			Expect(k8sClient.Get(ctx, cmStateName, cmState)).To(Succeed())
+			Expect(cmState.Spec.Audience).To(BeEmpty())
+		})
+
+		It("should ignore updates leaving the templates unchanged", func() {
+			pod := running.DeepCopy()
+			pod.Labels = map[string]string{"app": "changed"}
+
+			resp := hook.Handle(ctx, updateRequest(running, pod))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Warnings).To(BeEmpty())
+			Expect(recorder.Events).To(BeEmpty())
+		})
+
+		It("should not join cmstates in denied namespaces", func() {
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}})).To(Succeed())
+			hook.DeniedNamespaces = DefaultDeniedNamespaces
+
+			oldPod := newPod("app-")
+			oldPod.Namespace = "kube-system"
+			delete(oldPod.Annotations, TemplateAnnotation)
+			pod := oldPod.DeepCopy()
+			pod.Annotations[TemplateAnnotation] = "other"
+
+			resp := hook.Handle(ctx, updateRequest(oldPod, pod))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Warnings).To(BeEmpty())
+			Expect(recorder.Events).To(BeEmpty())
+
+			cmStates := &cachev1alpha1.CMStateList{}
+			Expect(k8sClient.List(ctx, cmStates, client.InNamespace("kube-system"))).To(Succeed())
+			Expect(cmStates.Items).To(BeEmpty())
+		})
+
+		It("should not report templates the pod is not marked as injected with", func() {
+			oldPod := running.DeepCopy()
+			delete(oldPod.Annotations, InjectedAnnotation)
+			pod := oldPod.DeepCopy()
+			delete(pod.Annotations, TemplateAnnotation)
+
+			resp := hook.Handle(ctx, updateRequest(oldPod, pod))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Warnings).To(BeEmpty())
+			Expect(recorder.Events).To(BeEmpty())
+		})
+	})
+
+	Context("When injection is opted out", func() {
+		expectSkipped := func(pod *corev1.Pod, reason string) {
+			resp := hook.Handle(ctx, podRequest(v1admission.Create, pod))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Patches).To(BeEmpty())
+			Expect(resp.Result.Message).To(ContainSubstring(reason))
+
+			cmStates := &cachev1alpha1.CMStateList{}
+			Expect(k8sClient.List(ctx, cmStates)).To(Succeed())
+			Expect(cmStates.Items).To(BeEmpty())
+		}
+
+		It("should skip pods with the opt-out annotation", func() {
+			pod := newPod("app-")
+			pod.Annotations[InjectAnnotation] = "false"
+			expectSkipped(pod, "opt-out annotation")
+		})
+
+		It("should skip pods in denied namespaces", func() {
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}})).To(Succeed())
+			hook.DeniedNamespaces = DefaultDeniedNamespaces
+
+			pod := newPod("app-")
+			pod.Namespace = "kube-system"
+			expectSkipped(pod, "denied namespace kube-system")
+		})
+
+		It("should skip pods in namespaces with the opt-out label", func() {
+			namespace := &corev1.Namespace{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "default"}, namespace)).To(Succeed())
+			namespace.Labels = map[string]string{namespaceOptOutLabel: namespaceOptOutValue}
+			Expect(k8sClient.Update(ctx, namespace)).To(Succeed())
+
+			expectSkipped(newPod("app-"), "namespace default opting out")
+		})
+
+		It("should skip selected templates for pods naming no template", func() {
+			cmTemplate := &cachev1alpha1.CMTemplate{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: templateName}, cmTemplate)).To(Succeed())
+			cmTemplate.Spec.Selector = &cachev1alpha1.TemplateSelector{}
+			Expect(k8sClient.Update(ctx, cmTemplate)).To(Succeed())
+
+			pod := newPod("app-")
+			pod.Annotations[TemplateAnnotation] = TemplateOptOut
+			expectSkipped(pod, "opt-out annotation")
+		})
+	})
+
+	Context("When a template cannot be applied", func() {
+		var recorder *record.FakeRecorder
+
+		BeforeEach(func() {
+			recorder = record.NewFakeRecorder(10)
+			hook.Recorder = recorder
+		})
+
+		// missingTemplate names a template that does not exist, leaving the pod to the default policy
+		missingTemplate := func(policy cachev1alpha1.FailurePolicy) func(*corev1.Pod) {
+			return func(pod *corev1.Pod) {
+				hook.DefaultFailurePolicy = policy
+				pod.Annotations[TemplateAnnotation] = "missing"
+			}
+		}
+		otherNamespace := func(policy cachev1alpha1.NamespacePolicy) func(*corev1.Pod) {
+			return func(*corev1.Pod) {
+				cmTemplate := &cachev1alpha1.CMTemplate{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: templateName}, cmTemplate)).To(Succeed())
+				cmTemplate.Spec.AllowedNamespaces = []string{"other"}
+				cmTemplate.Spec.NamespacePolicy = policy
+				Expect(k8sClient.Update(ctx, cmTemplate)).To(Succeed())
+			}
+		}
+
+		invalidNamespaceSelector := func(policy cachev1alpha1.FailurePolicy) func(*corev1.Pod) {
+			return func(*corev1.Pod) {
+				cmTemplate := &cachev1alpha1.CMTemplate{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: templateName}, cmTemplate)).To(Succeed())
+				cmTemplate.Spec.NamespaceSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "team", Operator: "Near", Values: []string{"a"}},
+				}}
+				cmTemplate.Spec.FailurePolicy = policy
+				Expect(k8sClient.Update(ctx, cmTemplate)).To(Succeed())
+			}
+		}
+
+		DescribeTable("applying the policy",
+			func(setup func(*corev1.Pod), allowed bool, warnings int, event string) {
+				pod := newPod("app-")
+				setup(pod)
+
+				resp := hook.Handle(ctx, podRequest(v1admission.Create, pod))
+				Expect(resp.Allowed).To(Equal(allowed))
+				if !allowed {
+					Expect(resp.Result.Code).To(Equal(int32(http.StatusForbidden)), "denied by the policy, not errored")
+				}
+				Expect(resp.Patches).To(BeEmpty())
+				Expect(resp.Warnings).To(HaveLen(warnings))
+				Expect(recorder.Events).To(Receive(ContainSubstring(event)))
+
+				cmStates := &cachev1alpha1.CMStateList{}
+				Expect(k8sClient.List(ctx, cmStates)).To(Succeed())
+				Expect(cmStates.Items).To(BeEmpty())
+			},
+			Entry("a missing template under Deny", missingTemplate(cachev1alpha1.FailurePolicyDeny), false, 0, ReasonInjectionFailed),
+			Entry("a missing template under AllowWithWarning", missingTemplate(cachev1alpha1.FailurePolicyAllowWithWarning), true, 1, ReasonInjectionSkipped),
+			Entry("a missing template under AllowSilently", missingTemplate(cachev1alpha1.FailurePolicyAllowSilently), true, 0, ReasonInjectionSkipped),
+			Entry("a namespace that is not allowed under Deny", otherNamespace(cachev1alpha1.NamespacePolicyDeny), false, 0, ReasonInjectionFailed),
+			Entry("a namespace that is not allowed under Skip", otherNamespace(cachev1alpha1.NamespacePolicySkip), true, 1, ReasonInjectionSkipped),
+			Entry("an invalid namespace selector under Deny", invalidNamespaceSelector(cachev1alpha1.FailurePolicyDeny), false, 0, ReasonInjectionFailed),
+			Entry("an invalid namespace selector under AllowWithWarning", invalidNamespaceSelector(cachev1alpha1.FailurePolicyAllowWithWarning), true, 1, ReasonInjectionSkipped),
+		)
+	})
+
+	Context("When many pods are admitted at once", func() {
+		const pods = 50
+
+		var patches, conflicts atomic.Int32
+
+		BeforeEach(func() {
+			patches.Store(0)
+			conflicts.Store(0)
+			// Slow creates let every admission call see no cmstate before the first one exists
+			k8sClient = newClient(interceptor.Funcs{
+				Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+					time.Sleep(10 * time.Millisecond)
+					return c.Create(ctx, obj, opts...)
+				},
+				Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+					// Another replica patching first is too rare with the fake client to rely on
+					var err error
+					if patches.Add(1)%5 == 0 {
+						err = apierrors.NewConflict(cachev1alpha1.GroupVersion.WithResource("cmstates").GroupResource(), obj.GetName(), fmt.Errorf("object was modified"))
+					} else {
+						err = c.Patch(ctx, obj, patch, opts...)
+					}
+					if apierrors.IsConflict(err) {
+						conflicts.Add(1)
+					}
+					return err
+				},
+			})
+			hook = &cmStateCreator{Client: k8sClient, DefaultFailurePolicy: cachev1alpha1.FailurePolicyDeny, audienceLocks: newAudienceLocks()}
+		})
+
+		// Two handlers sharing the client but not their locks stand in for two webhook replicas
+		handle := func(operation v1admission.Operation) []admission.Response {
+			hooks := []*cmStateCreator{hook, {Client: k8sClient, DefaultFailurePolicy: cachev1alpha1.FailurePolicyDeny, audienceLocks: newAudienceLocks()}}
+
+			responses := make([]admission.Response, pods)
+			start := make(chan struct{})
+			var wg sync.WaitGroup
+			for i := range pods {
+				req := podRequest(operation, newPod(fmt.Sprintf("app-%d-", i)))
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer GinkgoRecover()
+					<-start
+					responses[i] = hooks[i%len(hooks)].Handle(ctx, req)
+				}()
+			}
+			close(start)
+			wg.Wait()
+			return responses
+		}
+
+		It("should admit and track every pod", func() {
+			for _, resp := range handle(v1admission.Create) {
+				Expect(resp.Allowed).To(BeTrue(), "%v", resp.Result)
+			}
+
+			cmState := &cachev1alpha1.CMState{}
+			Expect(k8sClient.Get(ctx, cmStateName, cmState)).To(Succeed())
+			Expect(cmState.Spec.Audience).To(HaveLen(pods))
+
+			By("Deleting every pod at once")
+			for _, resp := range handle(v1admission.Delete) {
+				Expect(resp.Allowed).To(BeTrue(), "%v", resp.Result)
+			}
+
+			Expect(k8sClient.Get(ctx, cmStateName, cmState)).To(Succeed())
+			Expect(cmState.Spec.Audience).To(BeEmpty())
+			Expect(conflicts.Load()).To(BeNumerically(">", 0), "the patches must have been retried")
+		})
+
+		It("should drop the locks it no longer needs", func() {
+			handle(v1admission.Create)
+			Expect(hook.audienceLocks.locks).To(BeEmpty())
+		})
+	})
+
+	Context("When the cmstate is removed while a pod joins it", func() {
+		It("should create the cmstate again", func() {
+			// The reconciler removes the cmstate, its audience emptied, after the webhook read it
+			removed := false
+			k8sClient = newClient(interceptor.Funcs{
+				Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+					if !removed {
+						removed = true
+						Expect(c.Delete(ctx, obj)).To(Succeed())
+					}
+					return c.Patch(ctx, obj, patch, opts...)
+				},
+			})
+			recorder := record.NewFakeRecorder(10)
+			hook = &cmStateCreator{Client: k8sClient, DefaultFailurePolicy: cachev1alpha1.FailurePolicyDeny, Recorder: recorder}
+			Expect(k8sClient.Create(ctx, &cachev1alpha1.CMState{
+				ObjectMeta: metav1.ObjectMeta{Name: cmStateName.Name, Namespace: cmStateName.Namespace},
+				Spec:       cachev1alpha1.CMStateSpec{CMTemplate: templateName},
+			})).To(Succeed())
+
+			resp := hook.Handle(ctx, podRequest(v1admission.Create, newPod("app-")))
+			Expect(resp.Allowed).To(BeTrue(), "%v", resp.Result)
+			Expect(removed).To(BeTrue())
+			Expect(recorder.Events).To(Receive(ContainSubstring(cachev1alpha1.EventReasonCMStateCreated)))
+
+			cmState := &cachev1alpha1.CMState{}
+			Expect(k8sClient.Get(ctx, cmStateName, cmState)).To(Succeed())
+			Expect(cmState.Spec.Audience).To(ConsistOf(cachev1alpha1.CMAudience{Kind: "Pod", Name: "app-"}))
+		})
+
+		It("should not bring it back for a pod leaving it", func() {
+			k8sClient = newClient(interceptor.Funcs{
+				Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+					Expect(c.Delete(ctx, obj)).To(Succeed())
+					return c.Patch(ctx, obj, patch, opts...)
+				},
+			})
+			hook = &cmStateCreator{Client: k8sClient, DefaultFailurePolicy: cachev1alpha1.FailurePolicyDeny}
+			Expect(k8sClient.Create(ctx, &cachev1alpha1.CMState{
+				ObjectMeta: metav1.ObjectMeta{Name: cmStateName.Name, Namespace: cmStateName.Namespace},
+				Spec: cachev1alpha1.CMStateSpec{
+					CMTemplate: templateName,
+					Audience:   []cachev1alpha1.CMAudience{{Kind: "Pod", Name: "app-"}},
+				},
+			})).To(Succeed())
+
+			resp := hook.Handle(ctx, podRequest(v1admission.Delete, newPod("app-")))
+			Expect(resp.Allowed).To(BeTrue(), "%v", resp.Result)
+			Expect(k8sClient.Get(ctx, cmStateName, &cachev1alpha1.CMState{})).NotTo(Succeed())
+		})
+	})
+})
@@ -0,0 +1,70 @@
+package v1alpha1
+
+import (
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	"github.com/stollenaar/cmstate-injector-operator/internal/audit"
+	"github.com/stollenaar/cmstate-injector-operator/internal/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// recordDecision counts the decision on a template and writes it to the audit log. The
+// pod, template and cmstate are nil when the decision was made without them.
+func (hook *cmStateCreator) recordDecision(req admission.Request, pod *corev1.Pod, templateName string,
+	cmTemplate *cachev1alpha1.CMTemplate, cmState *cachev1alpha1.CMState, outcome, message string) {
+	if hook.preview != nil {
+		// Previews are not admissions
+		return
+	}
+	metrics.AdmissionDecisions.WithLabelValues(string(req.Operation), outcome, templateLabel(templateName, cmTemplate, cmState)).Inc()
+	if hook.Audit == nil {
+		return
+	}
+
+	record := audit.Record{
+		Kind:       audit.KindAdmission,
+		RequestUID: string(req.UID),
+		UserInfo:   &req.UserInfo,
+		Operation:  string(req.Operation),
+		DryRun:     dryRun(req),
+		Namespace:  req.Namespace,
+		Pod:        req.Name,
+		Template:   templateName,
+		Outcome:    outcome,
+		Message:    message,
+	}
+	if pod != nil && pod.Name == "" {
+		// Pods being created often only have a generateName
+		record.Pod = pod.GenerateName
+	} else if pod != nil {
+		record.Pod = pod.Name
+	}
+	if cmTemplate != nil {
+		record.TemplateGeneration = cmTemplate.Generation
+	}
+	if cmState != nil && cmState.Name != "" {
+		record.CMState = cmState.Name
+		record.ConfigMap = cmState.Spec.Target
+		record.ValueHash = audit.Hash(cmState.Spec.Values)
+		record.Values = audit.Redact(cmTemplate, cmState.Spec.Values)
+	}
+	hook.Audit.Log(record)
+}
+
+// templateLabel returns the template to count a decision under. Names that did not
+// resolve to a template or its cmstate are collapsed into one label.
+func templateLabel(templateName string, cmTemplate *cachev1alpha1.CMTemplate, cmState *cachev1alpha1.CMState) string {
+	if templateName == "" || cmTemplate != nil || (cmState != nil && cmState.Name != "") {
+		return templateName
+	}
+	return metrics.UnknownTemplate
+}
+
+// failOutcome is the outcome of a template that could not be applied: the pod was
+// denied when there is a response, otherwise the template was left out.
+func failOutcome(resp *admission.Response) string {
+	if resp != nil {
+		return metrics.OutcomeDenied
+	}
+	return metrics.OutcomeFailed
+}
@@ -0,0 +1,61 @@
+package v1alpha1
+
+import (
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reasons of the events recorded for pods the webhook could not fully inject.
+const (
+	// ReasonInjectionSkipped is recorded when none of the pod's templates were injected.
+	ReasonInjectionSkipped = "InjectionSkipped"
+	// ReasonInjectionDegraded is recorded when only some of the pod's templates were injected.
+	ReasonInjectionDegraded = "InjectionDegraded"
+	// ReasonInjectionFailed is recorded when the pod was rejected or the webhook errored.
+	ReasonInjectionFailed = "InjectionFailed"
+	// ReasonSelectorFailed is recorded on a CMTemplate whose selector could not be matched against a pod.
+	ReasonSelectorFailed = "SelectorFailed"
+)
+
+// recordEvent records a warning event for the pod. Pods being created have no uid
+// and often no name yet, so their events go to the pod's controller when it has one.
+func (hook *cmStateCreator) recordEvent(pod *corev1.Pod, reason, message string) {
+	if hook.Recorder == nil {
+		return
+	}
+	hook.Recorder.Event(eventObject(pod), corev1.EventTypeWarning, reason, message)
+}
+
+// recordCMStateEvent records a normal event for the lifecycle of the cmstate.
+func (hook *cmStateCreator) recordCMStateEvent(cmState *cachev1alpha1.CMState, reason, message string) {
+	if hook.Recorder == nil {
+		return
+	}
+	hook.Recorder.Event(cmState, corev1.EventTypeNormal, reason, message)
+}
+
+// deny rejects the pod and records why.
+func (hook *cmStateCreator) deny(pod *corev1.Pod, reason string) *admission.Response {
+	hook.recordEvent(pod, ReasonInjectionFailed, reason)
+	resp := admission.Denied(reason)
+	return &resp
+}
+
+func eventObject(pod *corev1.Pod) runtime.Object {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || pod.UID != "" {
+		return pod
+	}
+	return &corev1.ObjectReference{
+		APIVersion: owner.APIVersion,
+		Kind:       owner.Kind,
+		Name:       owner.Name,
+		Namespace:  pod.Namespace,
+		UID:        owner.UID,
+	}
+}
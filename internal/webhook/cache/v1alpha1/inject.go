@@ -0,0 +1,114 @@
+package v1alpha1
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// TemplateNames returns the CMTemplates a pod asks for, in order: the comma separated
+// TemplateAnnotation first, then the indexed TemplateAnnotation.<n> annotations by index.
+func TemplateNames(annotations map[string]string) []string {
+	var names []string
+	add := func(name string) {
+		name = strings.TrimSpace(name)
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range strings.Split(annotations[TemplateAnnotation], ",") {
+		add(name)
+	}
+
+	type indexedName struct {
+		index int
+		name  string
+	}
+	var indexed []indexedName
+	for key, value := range annotations {
+		suffix, ok := strings.CutPrefix(key, TemplateAnnotation+".")
+		if !ok {
+			continue
+		}
+		index, err := strconv.Atoi(suffix)
+		if err != nil {
+			continue
+		}
+		indexed = append(indexed, indexedName{index: index, name: value})
+	}
+	slices.SortFunc(indexed, func(a, b indexedName) int { return cmp.Compare(a.index, b.index) })
+	for _, entry := range indexed {
+		add(entry.name)
+	}
+	return names
+}
+
+// checkTemplateConflicts returns an error when two templates would write the same
+// target annotation or mount at the same path.
+func checkTemplateConflicts(cmTemplates []*cachev1alpha1.CMTemplate) error {
+	targets := make(map[string]string)
+	mountPaths := make(map[string]string)
+	for _, cmTemplate := range cmTemplates {
+		target := cmTemplate.Spec.Template.TargetAnnotation
+		if other, ok := targets[target]; ok {
+			return fmt.Errorf("cmtemplates %s and %s both claim target annotation %s", other, cmTemplate.Name, target)
+		}
+		targets[target] = cmTemplate.Name
+
+		mountPath := cmTemplate.Spec.Template.MountPath
+		if mountPath == "" {
+			continue
+		}
+		if other, ok := mountPaths[mountPath]; ok {
+			return fmt.Errorf("cmtemplates %s and %s both claim mount path %s", other, cmTemplate.Name, mountPath)
+		}
+		mountPaths[mountPath] = cmTemplate.Name
+	}
+	return nil
+}
+
+// injectConfigMap makes the rendered ConfigMap available to every container of the pod,
+// as a volume mounted at the template's mount path and/or as environment variables.
+func injectConfigMap(patch *podPatch, cmTemplate *cachev1alpha1.CMTemplate, configMapName string) {
+	template := cmTemplate.Spec.Template
+	if template.MountPath != "" {
+		name := volumeName(cmTemplate.Name)
+		patch.addVolume(corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: configMapName},
+				},
+			},
+		})
+		patch.addVolumeMount(corev1.VolumeMount{Name: name, MountPath: template.MountPath, ReadOnly: true})
+	}
+	if template.EnvFrom {
+		patch.addEnvFrom(corev1.EnvFromSource{
+			ConfigMapRef: &corev1.ConfigMapEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: configMapName},
+			},
+		})
+	}
+}
+
+// volumeName returns the name of the volume holding the template's ConfigMap, shortened
+// with a hash suffix when the template name does not fit in a DNS label.
+func volumeName(templateName string) string {
+	name := CMStateName(templateName)
+	if len(name) <= validation.DNS1123LabelMaxLength {
+		return name
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(name))
+	suffix := strconv.FormatUint(uint64(hash.Sum32()), 16)
+	return strings.TrimRight(name[:validation.DNS1123LabelMaxLength-len(suffix)-1], "-.") + "-" + suffix
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Inject", func() {
+	DescribeTable("reading the templates a pod asks for",
+		func(annotations map[string]string, names []string) {
+			Expect(TemplateNames(annotations)).To(Equal(names))
+		},
+		Entry("no annotations", nil, nil),
+		Entry("a single template", map[string]string{TemplateAnnotation: "vault"}, []string{"vault"}),
+		Entry("a comma separated list", map[string]string{TemplateAnnotation: " vault, ,proxy "}, []string{"vault", "proxy"}),
+		Entry("indexed annotations by index", map[string]string{
+			TemplateAnnotation:         "vault",
+			TemplateAnnotation + ".10": "last",
+			TemplateAnnotation + ".2":  "proxy",
+		}, []string{"vault", "proxy", "last"}),
+		Entry("duplicates once", map[string]string{
+			TemplateAnnotation:        "vault,vault",
+			TemplateAnnotation + ".1": "vault",
+		}, []string{"vault"}),
+		Entry("annotations that are not indexed", map[string]string{
+			TemplateAnnotation + ".x":  "ignored",
+			TemplateAnnotation + "s.1": "ignored",
+			TemplateAnnotation + ".1":  "proxy",
+			"other.io/cmtemplate.2":    "ignored",
+		}, []string{"proxy"}),
+	)
+
+	template := func(name, target, mountPath string) *cachev1alpha1.CMTemplate {
+		return &cachev1alpha1.CMTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: cachev1alpha1.CMTemplateSpec{Template: cachev1alpha1.Template{
+				TargetAnnotation: target,
+				MountPath:        mountPath,
+			}},
+		}
+	}
+
+	DescribeTable("checking templates for conflicts",
+		func(cmTemplates []*cachev1alpha1.CMTemplate, conflict string) {
+			err := checkTemplateConflicts(cmTemplates)
+			if conflict == "" {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(conflict))
+			}
+		},
+		Entry("no templates", nil, ""),
+		Entry("distinct targets and mount paths", []*cachev1alpha1.CMTemplate{
+			template("vault", "test/vault", "/etc/vault"),
+			template("proxy", "test/proxy", "/etc/proxy"),
+		}, ""),
+		Entry("templates without mount path", []*cachev1alpha1.CMTemplate{
+			template("vault", "test/vault", ""),
+			template("proxy", "test/proxy", ""),
+		}, ""),
+		Entry("the same target annotation", []*cachev1alpha1.CMTemplate{
+			template("vault", "test/target", "/etc/vault"),
+			template("proxy", "test/target", "/etc/proxy"),
+		}, "cmtemplates vault and proxy both claim target annotation test/target"),
+		Entry("the same mount path", []*cachev1alpha1.CMTemplate{
+			template("vault", "test/vault", "/etc/config"),
+			template("proxy", "test/proxy", "/etc/config"),
+		}, "cmtemplates vault and proxy both claim mount path /etc/config"),
+	)
+})
@@ -0,0 +1,34 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// namespaceOptOutLabel set to namespaceOptOutValue keeps the webhook away from the pods of a namespace.
+	namespaceOptOutLabel = "cmstate.spicedelver.me"
+	namespaceOptOutValue = "opt-out"
+	// InjectAnnotation set to "false" keeps the webhook away from a pod, whatever templates it names or matches.
+	InjectAnnotation = "cache.spicedelver.me/inject"
+)
+
+// DefaultDeniedNamespaces are the system namespaces whose pods are never injected.
+var DefaultDeniedNamespaces = []string{"kube-system", "kube-public", "kube-node-lease"}
+
+// optOutReason returns why the pod must not be injected, or an empty string when it may be.
+func (hook *cmStateCreator) optOutReason(pod *corev1.Pod, namespace *corev1.Namespace) string {
+	if pod.GetAnnotations()[InjectAnnotation] == "false" {
+		return fmt.Sprintf("skipping cmstate injection due to opt-out annotation %s", InjectAnnotation)
+	}
+	if slices.Contains(hook.DeniedNamespaces, namespace.Name) {
+		return fmt.Sprintf("skipping cmstate injection in denied namespace %s", namespace.Name)
+	}
+	if namespace.GetLabels()[namespaceOptOutLabel] == namespaceOptOutValue {
+		return fmt.Sprintf("skipping cmstate injection due to namespace %s opting out with label %s=%s",
+			namespace.Name, namespaceOptOutLabel, namespaceOptOutValue)
+	}
+	return ""
+}
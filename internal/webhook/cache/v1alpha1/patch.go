@@ -0,0 +1,113 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// pointerEscaper escapes a key for use as a JSON pointer token, see RFC 6901.
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// podPatch collects the JSON patch operations mutating a pod. Every operation is
+// applied to the pod as well, so the pod reflects the patched state and the
+// operations always match the paths that exist.
+type podPatch struct {
+	pod *corev1.Pod
+	ops []PatchOperation
+}
+
+func newPodPatch(pod *corev1.Pod) *podPatch {
+	return &podPatch{pod: pod}
+}
+
+// setAnnotation sets the annotation on the pod, creating the annotations when it has none.
+func (p *podPatch) setAnnotation(key, value string) {
+	if current, ok := p.pod.Annotations[key]; ok && current == value {
+		return
+	}
+	if p.pod.Annotations == nil {
+		p.pod.Annotations = make(map[string]string)
+		p.add("/metadata/annotations", map[string]string{})
+	}
+	p.pod.Annotations[key] = value
+	p.add("/metadata/annotations/"+pointerEscaper.Replace(key), value)
+}
+
+// addVolume appends the volume to the pod, unless it has a volume of that name.
+func (p *podPatch) addVolume(volume corev1.Volume) {
+	if slices.ContainsFunc(p.pod.Spec.Volumes, func(v corev1.Volume) bool { return v.Name == volume.Name }) {
+		return
+	}
+	p.appendTo("/spec/volumes", len(p.pod.Spec.Volumes), volume)
+	p.pod.Spec.Volumes = append(p.pod.Spec.Volumes, volume)
+}
+
+// addVolumeMount appends the volume mount to every init and regular container that
+// does not mount the volume yet.
+func (p *podPatch) addVolumeMount(mount corev1.VolumeMount) {
+	p.forEachContainer(func(path string, container *corev1.Container) {
+		if slices.ContainsFunc(container.VolumeMounts, func(m corev1.VolumeMount) bool { return m.Name == mount.Name }) {
+			return
+		}
+		p.appendTo(path+"/volumeMounts", len(container.VolumeMounts), mount)
+		container.VolumeMounts = append(container.VolumeMounts, mount)
+	})
+}
+
+// addEnvFrom appends the environment source to every init and regular container that
+// does not have it yet.
+func (p *podPatch) addEnvFrom(envFrom corev1.EnvFromSource) {
+	p.forEachContainer(func(path string, container *corev1.Container) {
+		if slices.ContainsFunc(container.EnvFrom, func(e corev1.EnvFromSource) bool { return equality.Semantic.DeepEqual(e, envFrom) }) {
+			return
+		}
+		p.appendTo(path+"/envFrom", len(container.EnvFrom), envFrom)
+		container.EnvFrom = append(container.EnvFrom, envFrom)
+	})
+}
+
+// response admits the pod with the collected operations.
+func (p *podPatch) response(message string) admission.Response {
+	resp := admission.Allowed(message)
+	if len(p.ops) == 0 {
+		return resp
+	}
+
+	resp.Patches = make([]jsonpatch.JsonPatchOperation, 0, len(p.ops))
+	for _, op := range p.ops {
+		resp.Patches = append(resp.Patches, jsonpatch.JsonPatchOperation{Operation: op.Op, Path: op.Path, Value: op.Value})
+	}
+	patchType := admissionv1.PatchTypeJSONPatch
+	resp.PatchType = &patchType
+	return resp
+}
+
+func (p *podPatch) forEachContainer(fn func(path string, container *corev1.Container)) {
+	for i := range p.pod.Spec.InitContainers {
+		fn(fmt.Sprintf("/spec/initContainers/%d", i), &p.pod.Spec.InitContainers[i])
+	}
+	for i := range p.pod.Spec.Containers {
+		fn(fmt.Sprintf("/spec/containers/%d", i), &p.pod.Spec.Containers[i])
+	}
+}
+
+// appendTo appends the value to the array at path, which holds length items. The array
+// is created when it is empty, as it may be missing from the pod entirely.
+func (p *podPatch) appendTo(path string, length int, value any) {
+	if length == 0 {
+		p.add(path, []any{value})
+		return
+	}
+	p.add(path+"/-", value)
+}
+
+func (p *podPatch) add(path string, value any) {
+	p.ops = append(p.ops, PatchOperation{Op: "add", Path: path, Value: value})
+}
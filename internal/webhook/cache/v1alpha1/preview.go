@@ -0,0 +1,68 @@
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	v1admission "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Preview is what admitting a pod would do.
+type Preview struct {
+	// Response is the admission response, its patches mutate the pod.
+	Response admission.Response
+	// CMStates are the cmstates injected into the pod, with the pod in their audience.
+	CMStates []PreviewCMState
+}
+
+// PreviewCMState is a cmstate the pod would join, or create when it does not exist yet.
+type PreviewCMState struct {
+	CMState *cachev1alpha1.CMState `json:"cmstate"`
+	Created bool                   `json:"created"`
+}
+
+// PreviewPodCreate runs the admission of the pod as a dry run against the client, so
+// nothing is persisted and no decisions are recorded.
+func PreviewPodCreate(ctx context.Context, c client.Client, defaultFailurePolicy cachev1alpha1.FailurePolicy,
+	deniedNamespaces []string, pod *corev1.Pod) (*Preview, error) {
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding pod has resulted in an error")
+	}
+	preview := &Preview{}
+	hook := &cmStateCreator{
+		Client:               c,
+		DefaultFailurePolicy: defaultFailurePolicy,
+		DeniedNamespaces:     deniedNamespaces,
+		preview:              preview,
+	}
+	resp, err := hook.handleInner(ctx, admission.Request{AdmissionRequest: v1admission.AdmissionRequest{
+		Operation: v1admission.Create,
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		DryRun:    ptr.To(true),
+		Object:    runtime.RawExtension{Raw: raw},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	preview.Response = *resp
+	return preview, nil
+}
+
+// previewCMState adds the cmstate injected into the pod to the preview, if any.
+func (hook *cmStateCreator) previewCMState(cmState *cachev1alpha1.CMState, created bool, member cachev1alpha1.CMAudience) {
+	if hook.preview == nil {
+		return
+	}
+	cmState = cmState.DeepCopy()
+	cmState.Spec.Audience, _ = joinAudience(member)(cmState.Spec.Audience)
+	hook.preview.CMStates = append(hook.preview.CMStates, PreviewCMState{CMState: cmState, Created: created})
+}
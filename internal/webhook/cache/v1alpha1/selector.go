@@ -0,0 +1,122 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// celCostLimit bounds the runtime cost of a match condition, the per call limit Kubernetes
+// applies to the match conditions of admission webhooks. Every pod admission evaluates them.
+const celCostLimit = 1000000
+
+var (
+	celEnv = sync.OnceValues(func() (*cel.Env, error) {
+		return cel.NewEnv(
+			cel.Variable("object", cel.DynType),
+			cel.Variable("namespaceObject", cel.DynType),
+		)
+	})
+	// celPrograms caches compiled match conditions by expression.
+	celPrograms sync.Map
+)
+
+// selectorMatches reports whether the pod in the namespace is selected by the template selector.
+func selectorMatches(selector *cachev1alpha1.TemplateSelector, pod *corev1.Pod, namespace *corev1.Namespace) (bool, error) {
+	if ok, err := labelSelectorMatches(selector.PodSelector, pod.GetLabels()); !ok || err != nil {
+		return false, err
+	}
+	if ok, err := labelSelectorMatches(selector.NamespaceSelector, namespace.GetLabels()); !ok || err != nil {
+		return false, err
+	}
+	if len(selector.MatchConditions) == 0 {
+		return true, nil
+	}
+
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pod)
+	if err != nil {
+		return false, err
+	}
+	namespaceObject, err := runtime.DefaultUnstructuredConverter.ToUnstructured(namespace)
+	if err != nil {
+		return false, err
+	}
+	for _, condition := range selector.MatchConditions {
+		program, err := compileCondition(condition.Expression)
+		if err != nil {
+			return false, fmt.Errorf("compiling match condition %q: %w", condition.Name, err)
+		}
+		out, _, err := program.Eval(map[string]any{"object": object, "namespaceObject": namespaceObject})
+		if err != nil {
+			return false, fmt.Errorf("evaluating match condition %q: %w", condition.Name, err)
+		}
+		matched, ok := out.Value().(bool)
+		if !ok {
+			return false, fmt.Errorf("match condition %q did not evaluate to a bool", condition.Name)
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ValidateSelector returns why the label selectors or match conditions of the template
+// selector cannot be used, or nil when they can.
+func ValidateSelector(selector *cachev1alpha1.TemplateSelector) error {
+	if selector == nil {
+		return nil
+	}
+	if _, err := labelSelectorMatches(selector.PodSelector, nil); err != nil {
+		return fmt.Errorf("invalid pod selector: %w", err)
+	}
+	if _, err := labelSelectorMatches(selector.NamespaceSelector, nil); err != nil {
+		return fmt.Errorf("invalid namespace selector: %w", err)
+	}
+	for _, condition := range selector.MatchConditions {
+		if _, err := compileCondition(condition.Expression); err != nil {
+			return fmt.Errorf("compiling match condition %q: %w", condition.Name, err)
+		}
+	}
+	return nil
+}
+
+func labelSelectorMatches(labelSelector *metav1.LabelSelector, set map[string]string) (bool, error) {
+	if labelSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(set)), nil
+}
+
+func compileCondition(expression string) (cel.Program, error) {
+	if program, ok := celPrograms.Load(expression); ok {
+		return program.(cel.Program), nil
+	}
+	env, err := celEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if outputType := ast.OutputType(); outputType != cel.BoolType && outputType != cel.DynType {
+		return nil, fmt.Errorf("evaluates to %s instead of bool", outputType)
+	}
+	program, err := env.Program(ast, cel.CostLimit(celCostLimit))
+	if err != nil {
+		return nil, err
+	}
+	celPrograms.Store(expression, program)
+	return program, nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Selector", func() {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      "app",
+		Namespace: "apps",
+		Labels:    map[string]string{"app": "web"},
+	}}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "apps",
+		Labels: map[string]string{"team": "a"},
+	}}
+
+	labels := func(key, value string) *metav1.LabelSelector {
+		return &metav1.LabelSelector{MatchLabels: map[string]string{key: value}}
+	}
+	conditions := func(expressions ...string) []cachev1alpha1.MatchCondition {
+		var matchConditions []cachev1alpha1.MatchCondition
+		for i, expression := range expressions {
+			matchConditions = append(matchConditions, cachev1alpha1.MatchCondition{Name: fmt.Sprintf("c%d", i), Expression: expression})
+		}
+		return matchConditions
+	}
+	invalidLabels := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "app", Operator: "Near", Values: []string{"web"}},
+	}}
+
+	// costly iterates a million times, beyond the cost limit of a match condition
+	list := "[" + strings.TrimSuffix(strings.Repeat("1,", 100), ",") + "]"
+	costly := fmt.Sprintf("%[1]s.all(a, %[1]s.all(b, %[1]s.all(c, a == b)))", list)
+
+	DescribeTable("matching a pod",
+		func(selector cachev1alpha1.TemplateSelector, matches bool) {
+			Expect(selectorMatches(&selector, pod, namespace)).To(Equal(matches))
+		},
+		Entry("an empty selector", cachev1alpha1.TemplateSelector{}, true),
+		Entry("matching pod labels", cachev1alpha1.TemplateSelector{PodSelector: labels("app", "web")}, true),
+		Entry("other pod labels", cachev1alpha1.TemplateSelector{PodSelector: labels("app", "db")}, false),
+		Entry("matching namespace labels", cachev1alpha1.TemplateSelector{NamespaceSelector: labels("team", "a")}, true),
+		Entry("other namespace labels", cachev1alpha1.TemplateSelector{NamespaceSelector: labels("team", "b")}, false),
+		Entry("a condition on the pod", cachev1alpha1.TemplateSelector{
+			MatchConditions: conditions(`object.metadata.labels["app"] == "web"`),
+		}, true),
+		Entry("a condition on the namespace", cachev1alpha1.TemplateSelector{
+			MatchConditions: conditions(`namespaceObject.metadata.name.startsWith("kube-")`),
+		}, false),
+		Entry("conditions that must all hold", cachev1alpha1.TemplateSelector{
+			MatchConditions: conditions(`object.metadata.name == "app"`, `false`),
+		}, false),
+		Entry("labels and conditions", cachev1alpha1.TemplateSelector{
+			PodSelector:     labels("app", "web"),
+			MatchConditions: conditions(`has(object.metadata.labels.app)`),
+		}, true),
+	)
+
+	DescribeTable("failing on a pod",
+		func(selector cachev1alpha1.TemplateSelector, message string) {
+			_, err := selectorMatches(&selector, pod, namespace)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("an invalid pod selector", cachev1alpha1.TemplateSelector{PodSelector: invalidLabels}, "not a valid label selector operator"),
+		Entry("an invalid namespace selector", cachev1alpha1.TemplateSelector{NamespaceSelector: invalidLabels}, "not a valid label selector operator"),
+		Entry("a condition that does not compile", cachev1alpha1.TemplateSelector{MatchConditions: conditions(`object.metadata.`)}, "compiling match condition"),
+		Entry("a condition that is not a bool", cachev1alpha1.TemplateSelector{MatchConditions: conditions(`1 + 1`)}, "instead of bool"),
+		Entry("a condition that evaluates to something else", cachev1alpha1.TemplateSelector{MatchConditions: conditions(`object.metadata.name`)}, "did not evaluate to a bool"),
+		Entry("a condition on a missing field", cachev1alpha1.TemplateSelector{MatchConditions: conditions(`object.spec.missing == "x"`)}, "evaluating match condition"),
+		Entry("a condition that is too expensive", cachev1alpha1.TemplateSelector{MatchConditions: conditions(costly)}, "cost limit exceeded"),
+	)
+
+	DescribeTable("validating a selector",
+		func(selector *cachev1alpha1.TemplateSelector, valid bool) {
+			if valid {
+				Expect(ValidateSelector(selector)).To(Succeed())
+			} else {
+				Expect(ValidateSelector(selector)).NotTo(Succeed())
+			}
+		},
+		Entry("no selector", nil, true),
+		Entry("a valid selector", &cachev1alpha1.TemplateSelector{
+			PodSelector:     labels("app", "web"),
+			MatchConditions: conditions(`object.metadata.name == "app"`),
+		}, true),
+		Entry("an invalid pod selector", &cachev1alpha1.TemplateSelector{PodSelector: invalidLabels}, false),
+		Entry("an invalid namespace selector", &cachev1alpha1.TemplateSelector{NamespaceSelector: invalidLabels}, false),
+		Entry("a condition that does not compile", &cachev1alpha1.TemplateSelector{MatchConditions: conditions(`object.metadata.`)}, false),
+	)
+})
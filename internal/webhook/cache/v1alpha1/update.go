@@ -0,0 +1,156 @@
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	"github.com/stollenaar/cmstate-injector-operator/internal/workload"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// ReasonTemplateMismatch is recorded when the template annotations of a running pod no
+// longer match the ConfigMaps it mounts.
+const ReasonTemplateMismatch = "CMTemplateMismatch"
+
+// handlePodUpdate follows changes to the template annotations of a running pod. Its
+// volumes cannot change anymore, so the pod is moved between audiences and the
+// mismatch with what it mounts is recorded on the pod until it is recreated.
+func (hook *cmStateCreator) handlePodUpdate(req admission.Request, oldPod, pod *corev1.Pod, ctx context.Context) (*admission.Response, error) {
+	injected := InjectedTemplates(oldPod)
+	oldNames := TemplateNames(oldPod.GetAnnotations())
+	newNames := TemplateNames(pod.GetAnnotations())
+	if slices.Contains(newNames, TemplateOptOut) || pod.GetAnnotations()[InjectAnnotation] == "false" {
+		newNames = nil
+	}
+
+	var mismatches []string
+	for _, templateName := range newNames {
+		if !slices.Contains(injected, templateName) {
+			continue
+		}
+		cmTemplate := &cachev1alpha1.CMTemplate{}
+		err := hook.Client.Get(ctx, types.NamespacedName{Name: templateName}, cmTemplate)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrap(err, "fetching cmtemplate has resulted in an error")
+		}
+		for annotation := range cmTemplate.Spec.Template.AnnotationReplace {
+			if oldPod.GetAnnotations()[annotation] != pod.GetAnnotations()[annotation] {
+				mismatches = append(mismatches, fmt.Sprintf(
+					"annotation %s changed, cmstate %s keeps the value the pod was injected with", annotation, CMStateName(templateName)))
+			}
+		}
+	}
+
+	// Only templates the pod was injected with, or newly names, change its audiences
+	removed := slices.DeleteFunc(slices.Clone(injected), func(name string) bool { return slices.Contains(newNames, name) })
+	added := slices.DeleteFunc(slices.Clone(newNames), func(name string) bool {
+		return slices.Contains(injected, name) || slices.Contains(oldNames, name)
+	})
+
+	namespace := &corev1.Namespace{}
+	if len(added) > 0 {
+		if err := hook.Client.Get(ctx, types.NamespacedName{Name: req.Namespace}, namespace); err != nil {
+			return nil, errors.Wrap(err, "fetching namespace has resulted in an error")
+		}
+		if hook.optOutReason(pod, namespace) != "" {
+			added = nil
+		}
+	}
+	if len(removed) == 0 && len(added) == 0 && len(mismatches) == 0 {
+		resp := admission.Allowed("skipping cmstate update due to unchanged cmtemplates")
+		return &resp, nil
+	}
+
+	member, err := hook.resolver().AudienceFor(ctx, req.Namespace, pod)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving pod workload has resulted in an error")
+	}
+	legacyMember := cachev1alpha1.CMAudience{Kind: workload.KindPod, Name: pod.GetGenerateName()}
+
+	for _, templateName := range removed {
+		cmState := &cachev1alpha1.CMState{}
+		err := hook.Client.Get(ctx, types.NamespacedName{Namespace: req.Namespace, Name: CMStateName(templateName)}, cmState)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, errors.Wrap(err, "fetching cmstate has resulted in an error")
+		}
+		if err == nil && !dryRun(req) {
+			if _, err := hook.patchAudience(ctx, cmState, leaveAudience(member, legacyMember)); err != nil {
+				return nil, errors.Wrap(err, "patching cmstate has resulted in an error")
+			}
+		}
+		mismatches = append(mismatches, fmt.Sprintf(
+			"pod no longer uses cmtemplate %s but mounts its configmap until it is recreated", templateName))
+	}
+
+	for _, templateName := range added {
+		reason, err := hook.joinTemplate(ctx, req, pod, namespace, templateName, member)
+		if err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, reason)
+	}
+
+	message := fmt.Sprintf("cmtemplates of the pod changed: %s", strings.Join(mismatches, "; "))
+	hook.recordEvent(pod, ReasonTemplateMismatch, message)
+	resp := admission.Allowed(message).WithWarnings(mismatches...)
+	return &resp, nil
+}
+
+// joinTemplate adds a running pod to the audience of the template's cmstate, creating
+// it when needed. It returns the mismatch to report for the pod.
+func (hook *cmStateCreator) joinTemplate(ctx context.Context, req admission.Request, pod *corev1.Pod, namespace *corev1.Namespace,
+	templateName string, member cachev1alpha1.CMAudience) (string, error) {
+	cmTemplate := &cachev1alpha1.CMTemplate{}
+	err := hook.Client.Get(ctx, types.NamespacedName{Name: templateName}, cmTemplate)
+	if apierrors.IsNotFound(err) {
+		return fmt.Sprintf("cmtemplate %s does not exist", templateName), nil
+	} else if err != nil {
+		return "", errors.Wrap(err, "fetching cmtemplate has resulted in an error")
+	}
+
+	allowed, reason, err := cmTemplate.AllowsNamespace(namespace)
+	if err != nil {
+		return fmt.Sprintf("checking namespace %s against cmtemplate %s: %v", namespace.Name, templateName, err), nil
+	}
+	if !allowed {
+		return reason, nil
+	}
+
+	cmState := &cachev1alpha1.CMState{}
+	err = hook.Client.Get(ctx, types.NamespacedName{Namespace: req.Namespace, Name: CMStateName(templateName)}, cmState)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", errors.Wrap(err, "fetching cmstate has resulted in an error")
+	}
+	if !dryRun(req) {
+		if err == nil {
+			_, err = hook.patchAudience(ctx, cmState, joinAudience(member))
+		} else if cmState, _, err = generateCMState(cmTemplate, pod, namespace, member); err == nil {
+			_, err = hook.createCMState(ctx, cmState, member)
+		}
+		if err != nil {
+			return fmt.Sprintf("joining cmstate of cmtemplate %s: %v", templateName, err), nil
+		}
+	}
+	return fmt.Sprintf("pod uses cmtemplate %s once it is recreated", templateName), nil
+}
+
+// InjectedTemplates returns the templates the marker of the pod lists as injected. Pods
+// injected by older versions carry no marker, what they mount is unknown.
+func InjectedTemplates(pod *corev1.Pod) []string {
+	var names []string
+	for _, name := range strings.Split(pod.GetAnnotations()[InjectedAnnotation], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,80 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var containerFieldPath = regexp.MustCompile(`^spec\.containers\[([^\]]+)\]\.(image|ports\[([^\]]+)\])$`)
+
+// podValues resolves the value sources of the template that are read from the
+// pod or its namespace. The result is keyed by value source name, values whose
+// label or annotation is not set are empty and listed in missing.
+func podValues(cmTemplate *cachev1alpha1.CMTemplate, pod *corev1.Pod, namespace *corev1.Namespace) (values map[string]string, missing []string, err error) {
+	values = make(map[string]string)
+	for _, source := range cmTemplate.Spec.Template.ValueSources {
+		if !source.FromPod() {
+			continue
+		}
+		var value string
+		found := true
+		switch {
+		case source.PodLabel != "":
+			value, found = pod.GetLabels()[source.PodLabel]
+		case source.PodAnnotation != "":
+			value, found = pod.GetAnnotations()[source.PodAnnotation]
+		case source.NamespaceLabel != "":
+			value, found = namespace.GetLabels()[source.NamespaceLabel]
+		case source.NamespaceAnnotation != "":
+			value, found = namespace.GetAnnotations()[source.NamespaceAnnotation]
+		case source.PodField != "":
+			value, err = podFieldValue(pod, source.PodField)
+			if err != nil {
+				return nil, nil, fmt.Errorf("resolving value %q: %w", source.Name, err)
+			}
+		}
+		if !found {
+			missing = append(missing, source.Name)
+		}
+		values[source.Name] = value
+	}
+	return values, missing, nil
+}
+
+func podFieldValue(pod *corev1.Pod, path string) (string, error) {
+	switch path {
+	case "metadata.name":
+		return pod.GetName(), nil
+	case "metadata.namespace":
+		return pod.GetNamespace(), nil
+	case "spec.serviceAccountName":
+		if pod.Spec.ServiceAccountName == "" {
+			return "default", nil
+		}
+		return pod.Spec.ServiceAccountName, nil
+	}
+
+	match := containerFieldPath.FindStringSubmatch(path)
+	if match == nil {
+		return "", fmt.Errorf("unsupported pod field %q", path)
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name != match[1] {
+			continue
+		}
+		if match[2] == "image" {
+			return container.Image, nil
+		}
+		for _, port := range container.Ports {
+			if port.Name == match[3] {
+				return strconv.Itoa(int(port.ContainerPort)), nil
+			}
+		}
+		return "", fmt.Errorf("container %q has no port named %q", match[1], match[3])
+	}
+	return "", fmt.Errorf("pod has no container named %q", match[1])
+}
@@ -0,0 +1,275 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package workload maps pods to the workloads that track them in a CMState audience.
+package workload
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+)
+
+// Audience kinds of a CMState.
+const (
+	KindPod         = "Pod"
+	KindDeployment  = "Deployment"
+	KindReplicaSet  = "ReplicaSet"
+	KindStatefulSet = "StatefulSet"
+	KindDaemonSet   = "DaemonSet"
+	KindJob         = "Job"
+	KindCronJob     = "CronJob"
+)
+
+// maxOwnerDepth bounds the walk up the controller chain, guarding against owner cycles.
+const maxOwnerDepth = 10
+
+// builtinGroups maps the built-in workload kinds to their API group. Audience members
+// of these kinds carry no apiVersion.
+var builtinGroups = map[string]string{
+	KindDeployment:  appsv1.GroupName,
+	KindReplicaSet:  appsv1.GroupName,
+	KindStatefulSet: appsv1.GroupName,
+	KindDaemonSet:   appsv1.GroupName,
+	KindJob:         batchv1.GroupName,
+	KindCronJob:     batchv1.GroupName,
+}
+
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets;replicasets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs;cronjobs,verbs=get;list;watch
+
+// Objects returns an empty object for every built-in workload kind, e.g. to set up watches.
+func Objects() []client.Object {
+	return []client.Object{
+		&appsv1.Deployment{},
+		&appsv1.ReplicaSet{},
+		&appsv1.StatefulSet{},
+		&appsv1.DaemonSet{},
+		&batchv1.Job{},
+		&batchv1.CronJob{},
+	}
+}
+
+// KindOf returns the audience kind of a built-in workload object, or an empty string
+// when the object is not one.
+func KindOf(obj client.Object) string {
+	switch obj.(type) {
+	case *appsv1.Deployment:
+		return KindDeployment
+	case *appsv1.ReplicaSet:
+		return KindReplicaSet
+	case *appsv1.StatefulSet:
+		return KindStatefulSet
+	case *appsv1.DaemonSet:
+		return KindDaemonSet
+	case *batchv1.Job:
+		return KindJob
+	case *batchv1.CronJob:
+		return KindCronJob
+	}
+	return ""
+}
+
+// IsWorkload reports whether the audience member is a workload rather than a pod.
+func IsWorkload(member cachev1alpha1.CMAudience) bool {
+	return member.Kind != KindPod
+}
+
+// IsCustom reports whether the audience member is a workload of a custom controller.
+// Those are not watched, so their CMStates have to be resynced periodically.
+func IsCustom(member cachev1alpha1.CMAudience) bool {
+	return member.APIVersion != ""
+}
+
+// Resolver resolves the workloads of pods and whether they still need their CMStates.
+// Built-in workloads are read through Client, workloads of custom controllers through
+// APIReader, which does not need list and watch permissions on them. The operator
+// needs get permissions on those custom resources, otherwise their lookups fail.
+type Resolver struct {
+	Client client.Reader
+	// APIReader defaults to Client when unset.
+	APIReader client.Reader
+}
+
+// AudienceFor returns the audience member tracking the pod: the top of its chain of
+// controllers, or the pod itself when it has none. Pods are tracked by their
+// generateName, so the pods of one owner share a single entry. Controllers that are
+// gone or cannot be read end the chain like the top does.
+func (r *Resolver) AudienceFor(ctx context.Context, namespace string, pod *corev1.Pod) (cachev1alpha1.CMAudience, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return podMember(pod), nil
+	}
+
+	top := *owner
+	for range maxOwnerDepth {
+		obj, err := r.get(ctx, namespace, top)
+		if unreadable(err) && top == *owner {
+			// The pod outlived its controller, or its controller is not for the operator to read
+			return podMember(pod), nil
+		} else if unreadable(err) {
+			break
+		} else if err != nil {
+			return cachev1alpha1.CMAudience{}, fmt.Errorf("resolving owner %s %s: %w", top.Kind, top.Name, err)
+		}
+
+		parent := metav1.GetControllerOf(obj)
+		if parent == nil {
+			break
+		}
+		top = *parent
+	}
+	return memberFor(top), nil
+}
+
+// Alive reports whether the workload still needs its CMState: it exists, desires at
+// least one replica when it has a replica count, and is not finished when it is a Job.
+func (r *Resolver) Alive(ctx context.Context, namespace string, member cachev1alpha1.CMAudience) (bool, error) {
+	if !IsWorkload(member) {
+		// Pods are released by the webhook when they are deleted
+		return true, nil
+	}
+
+	obj, err := r.get(ctx, namespace, metav1.OwnerReference{APIVersion: member.APIVersion, Kind: member.Kind, Name: member.Name})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if unreadable(err) {
+		// Members the operator cannot read are kept until their pods leave
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	switch obj := obj.(type) {
+	case *appsv1.Deployment:
+		return replicasAlive(obj.Spec.Replicas), nil
+	case *appsv1.ReplicaSet:
+		return replicasAlive(obj.Spec.Replicas), nil
+	case *appsv1.StatefulSet:
+		return replicasAlive(obj.Spec.Replicas), nil
+	case *batchv1.Job:
+		return jobAlive(obj), nil
+	case *unstructured.Unstructured:
+		replicas, found, err := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+		if err != nil || !found {
+			// Not scalable, it lives as long as it exists
+			return true, nil
+		}
+		return replicas != 0, nil
+	}
+	return true, nil
+}
+
+// get reads the object an owner reference points to.
+func (r *Resolver) get(ctx context.Context, namespace string, ref metav1.OwnerReference) (client.Object, error) {
+	key := types.NamespacedName{Namespace: namespace, Name: ref.Name}
+
+	var obj client.Object
+	if group, ok := builtinGroups[ref.Kind]; ok && (ref.APIVersion == "" || groupOf(ref.APIVersion) == group) {
+		switch ref.Kind {
+		case KindDeployment:
+			obj = &appsv1.Deployment{}
+		case KindReplicaSet:
+			obj = &appsv1.ReplicaSet{}
+		case KindStatefulSet:
+			obj = &appsv1.StatefulSet{}
+		case KindDaemonSet:
+			obj = &appsv1.DaemonSet{}
+		case KindJob:
+			obj = &batchv1.Job{}
+		case KindCronJob:
+			obj = &batchv1.CronJob{}
+		}
+		return obj, r.Client.Get(ctx, key, obj)
+	}
+
+	if ref.APIVersion == "" {
+		return nil, fmt.Errorf("unknown workload kind %s without apiVersion", ref.Kind)
+	}
+	custom := &unstructured.Unstructured{}
+	custom.SetAPIVersion(ref.APIVersion)
+	custom.SetKind(ref.Kind)
+	return custom, r.apiReader().Get(ctx, key, custom)
+}
+
+// unreadable reports whether an owner lookup failed because the owner is gone, or
+// because the operator may not read it or its kind is not served.
+func unreadable(err error) bool {
+	return apierrors.IsNotFound(err) || apierrors.IsForbidden(err) || meta.IsNoMatchError(err)
+}
+
+func (r *Resolver) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// memberFor returns the audience member for the workload an owner reference points to.
+func memberFor(ref metav1.OwnerReference) cachev1alpha1.CMAudience {
+	if group, ok := builtinGroups[ref.Kind]; ok && groupOf(ref.APIVersion) == group {
+		return cachev1alpha1.CMAudience{Kind: ref.Kind, Name: ref.Name}
+	}
+	return cachev1alpha1.CMAudience{APIVersion: ref.APIVersion, Kind: ref.Kind, Name: ref.Name}
+}
+
+func groupOf(apiVersion string) string {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return ""
+	}
+	return gv.Group
+}
+
+// replicasAlive reports whether a replica count, which defaults to 1, is not scaled to zero.
+func replicasAlive(replicas *int32) bool {
+	return ptr.Deref(replicas, 1) != 0
+}
+
+// jobAlive reports whether the Job still has pods running or completions to go.
+func jobAlive(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) &&
+			condition.Status == corev1.ConditionTrue {
+			return false
+		}
+	}
+	if job.Status.Active > 0 {
+		return true
+	}
+	return job.Status.Succeeded < ptr.Deref(job.Spec.Completions, 1)
+}
+
+func podMember(pod *corev1.Pod) cachev1alpha1.CMAudience {
+	name := pod.GetName()
+	if pod.GetGenerateName() != "" {
+		name = pod.GetGenerateName()
+	}
+	return cachev1alpha1.CMAudience{Kind: KindPod, Name: name}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workload
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	cachev1alpha1 "github.com/stollenaar/cmstate-injector-operator/api/v1alpha1"
+)
+
+func controllerRef(apiVersion, kind, name string) []metav1.OwnerReference {
+	return []metav1.OwnerReference{{APIVersion: apiVersion, Kind: kind, Name: name, Controller: ptr.To(true)}}
+}
+
+var _ = Describe("Resolver", func() {
+	var (
+		ctx      context.Context
+		resolver *Resolver
+	)
+
+	rollout := func(replicas int64) *unstructured.Unstructured {
+		rollout := &unstructured.Unstructured{Object: map[string]any{
+			"spec": map[string]any{"replicas": replicas},
+		}}
+		rollout.SetAPIVersion("argoproj.io/v1alpha1")
+		rollout.SetKind("Rollout")
+		rollout.SetName("rollout")
+		rollout.SetNamespace("default")
+		return rollout
+	}
+
+	newResolver := func(objs ...client.Object) *Resolver {
+		return &Resolver{Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objs...).Build()}
+	}
+
+	// failingResolver fails to read the objects whose names have an error
+	failingResolver := func(failures map[string]error, objs ...client.Object) *Resolver {
+		return &Resolver{Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objs...).
+			WithInterceptorFuncs(interceptor.Funcs{
+				Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+					if err, ok := failures[key.Name]; ok {
+						return err
+					}
+					return c.Get(ctx, key, obj, opts...)
+				},
+			}).Build()}
+	}
+	forbidden := errors.NewForbidden(schema.GroupResource{Group: "apps", Resource: "replicasets"}, "app-1", nil)
+	noKindMatch := &meta.NoKindMatchError{GroupKind: schema.GroupKind{Group: "argoproj.io", Kind: "Rollout"}}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	Context("When resolving the audience of a pod", func() {
+		It("should follow the controller chain to the top", func() {
+			resolver = newResolver(
+				&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}},
+				&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+					Name: "app-1", Namespace: "default", OwnerReferences: controllerRef("apps/v1", KindDeployment, "app"),
+				}},
+				&batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+					Name: "backup-1", Namespace: "default", OwnerReferences: controllerRef("batch/v1", KindCronJob, "backup"),
+				}},
+				&batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default"}},
+			)
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "app-1-", OwnerReferences: controllerRef("apps/v1", KindReplicaSet, "app-1"),
+			}}
+			Expect(resolver.AudienceFor(ctx, "default", pod)).To(Equal(cachev1alpha1.CMAudience{Kind: KindDeployment, Name: "app"}))
+
+			pod.OwnerReferences = controllerRef("batch/v1", KindJob, "backup-1")
+			Expect(resolver.AudienceFor(ctx, "default", pod)).To(Equal(cachev1alpha1.CMAudience{Kind: KindCronJob, Name: "backup"}))
+		})
+
+		It("should track pods of custom controllers by the custom resource", func() {
+			resolver = newResolver(
+				rollout(1),
+				&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+					Name: "rollout-1", Namespace: "default", OwnerReferences: controllerRef("argoproj.io/v1alpha1", "Rollout", "rollout"),
+				}},
+			)
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "rollout-1-", OwnerReferences: controllerRef("apps/v1", KindReplicaSet, "rollout-1"),
+			}}
+			Expect(resolver.AudienceFor(ctx, "default", pod)).To(Equal(cachev1alpha1.CMAudience{
+				APIVersion: "argoproj.io/v1alpha1", Kind: "Rollout", Name: "rollout",
+			}))
+		})
+
+		It("should fall back to the pod when its controller is gone", func() {
+			resolver = newResolver()
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "app-1-", OwnerReferences: controllerRef("apps/v1", KindReplicaSet, "app-1"),
+			}}
+			Expect(resolver.AudienceFor(ctx, "default", pod)).To(Equal(cachev1alpha1.CMAudience{Kind: KindPod, Name: "app-1-"}))
+		})
+
+		It("should fall back to the pod when its controller cannot be read", func() {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "app-1-", OwnerReferences: controllerRef("apps/v1", KindReplicaSet, "app-1"),
+			}}
+
+			resolver = failingResolver(map[string]error{"app-1": forbidden})
+			Expect(resolver.AudienceFor(ctx, "default", pod)).To(Equal(cachev1alpha1.CMAudience{Kind: KindPod, Name: "app-1-"}))
+
+			pod.OwnerReferences = controllerRef("argoproj.io/v1alpha1", "Rollout", "app-1")
+			resolver = failingResolver(map[string]error{"app-1": noKindMatch})
+			Expect(resolver.AudienceFor(ctx, "default", pod)).To(Equal(cachev1alpha1.CMAudience{Kind: KindPod, Name: "app-1-"}))
+		})
+
+		It("should stop at the last controller it can read", func() {
+			resolver = failingResolver(map[string]error{"rollout": noKindMatch},
+				&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+					Name: "rollout-1", Namespace: "default", OwnerReferences: controllerRef("argoproj.io/v1alpha1", "Rollout", "rollout"),
+				}},
+			)
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "rollout-1-", OwnerReferences: controllerRef("apps/v1", KindReplicaSet, "rollout-1"),
+			}}
+			Expect(resolver.AudienceFor(ctx, "default", pod)).To(Equal(cachev1alpha1.CMAudience{
+				APIVersion: "argoproj.io/v1alpha1", Kind: "Rollout", Name: "rollout",
+			}))
+		})
+
+		It("should fail on other errors", func() {
+			resolver = failingResolver(map[string]error{"app-1": errors.NewServiceUnavailable("etcd is unavailable")})
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "app-1-", OwnerReferences: controllerRef("apps/v1", KindReplicaSet, "app-1"),
+			}}
+			_, err := resolver.AudienceFor(ctx, "default", pod)
+			Expect(err).To(MatchError(ContainSubstring("resolving owner ReplicaSet app-1")))
+		})
+	})
+
+	Context("When checking whether a workload is alive", func() {
+		It("should release finished Jobs", func() {
+			resolver = newResolver(
+				&batchv1.Job{
+					ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "default"},
+					Spec:       batchv1.JobSpec{Completions: ptr.To[int32](3)},
+					Status:     batchv1.JobStatus{Succeeded: 2},
+				},
+				&batchv1.Job{
+					ObjectMeta: metav1.ObjectMeta{Name: "done", Namespace: "default"},
+					Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+						{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+					}},
+				},
+			)
+
+			Expect(resolver.Alive(ctx, "default", cachev1alpha1.CMAudience{Kind: KindJob, Name: "running"})).To(BeTrue())
+			Expect(resolver.Alive(ctx, "default", cachev1alpha1.CMAudience{Kind: KindJob, Name: "done"})).To(BeFalse())
+			Expect(resolver.Alive(ctx, "default", cachev1alpha1.CMAudience{Kind: KindJob, Name: "gone"})).To(BeFalse())
+		})
+
+		It("should release custom resources scaled to zero", func() {
+			member := cachev1alpha1.CMAudience{APIVersion: "argoproj.io/v1alpha1", Kind: "Rollout", Name: "rollout"}
+
+			resolver = newResolver(rollout(2))
+			Expect(resolver.Alive(ctx, "default", member)).To(BeTrue())
+
+			resolver = newResolver(rollout(0))
+			Expect(resolver.Alive(ctx, "default", member)).To(BeFalse())
+		})
+
+		It("should keep workloads it cannot read", func() {
+			resolver = failingResolver(map[string]error{"rollout": forbidden})
+			Expect(resolver.Alive(ctx, "default", cachev1alpha1.CMAudience{APIVersion: "argoproj.io/v1alpha1", Kind: "Rollout", Name: "rollout"})).To(BeTrue())
+		})
+	})
+})